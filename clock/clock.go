@@ -0,0 +1,43 @@
+// Package clock abstracts the current time and tickers, so expiry, activity
+// and garbage collection decisions can be driven by a fake clock, such as
+// Fake, in tests.
+package clock
+
+import "time"
+
+// Clock tells the current time and creates tickers.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker delivering ticks every d, like
+	// time.NewTicker.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker.
+type Ticker interface {
+	// C returns the channel the ticks are delivered on.
+	C() <-chan time.Time
+	// Stop turns off the ticker. It does not close the channel.
+	Stop()
+}
+
+// System is the Clock backed by the time package.
+var System Clock = system{}
+
+type system struct{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
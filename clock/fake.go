@@ -0,0 +1,86 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a manually advanced Clock. It only moves when told to, so
+// lifetime, expiry and garbage collection behavior can be tested without
+// sleeping. Its tickers fire as Advance or Set moves the time past their
+// next tick; like time.Ticker they drop ticks a slow receiver misses.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+// NewFake returns a fake clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &ticker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d.
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to now.
+func (c *Fake) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(now)
+}
+
+func (c *Fake) set(now time.Time) {
+	c.now = now
+	for _, t := range c.tickers {
+		if t.next.After(now) {
+			continue
+		}
+		select {
+		case t.c <- t.next:
+		default:
+		}
+		t.next = t.next.Add((now.Sub(t.next)/t.period + 1) * t.period)
+	}
+}
+
+type ticker struct {
+	clock  *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *ticker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.tickers = slices.DeleteFunc(t.clock.tickers, func(other *ticker) bool {
+		return other == t
+	})
+}
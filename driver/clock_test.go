@@ -0,0 +1,70 @@
+package driver_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/driver"
+)
+
+const clockTestID = "12345678901234567890123456789012"
+
+func testDriverFollowsClock(t *testing.T, d driver.Driver, clk *clock.Fake) {
+	t.Helper()
+
+	if err := d.Write(clockTestID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	clk.Advance(9 * time.Minute)
+	if found, err := d.Touch(clockTestID); !found || err != nil {
+		t.Fatalf("Touch within lifetime: found=%v err=%v", found, err)
+	}
+
+	clk.Advance(9 * time.Minute)
+	if _, found, err := d.Read(clockTestID); !found || err != nil {
+		t.Fatalf("Read after Touch: found=%v err=%v, want the touch to extend the lifetime", found, err)
+	}
+	if err := d.Gc(600); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	if _, found, _ := d.Read(clockTestID); !found {
+		t.Fatal("Gc removed a session idle for less than maxLifetime")
+	}
+
+	clk.Advance(2 * time.Minute)
+	if _, found, err := d.Read(clockTestID); found || err != nil {
+		t.Fatalf("Read after the lifetime: found=%v err=%v, want found=false", found, err)
+	}
+	if err := d.Gc(600); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	clk.Advance(-time.Hour)
+	if _, found, _ := d.Read(clockTestID); found {
+		t.Fatal("Gc kept a session idle for longer than maxLifetime")
+	}
+}
+
+func TestFileFollowsClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	testDriverFollowsClock(t, driver.NewFileWithOptions(driver.FileOptions{
+		Path:     filepath.Join(t.TempDir(), "sessions"),
+		Lifetime: 10,
+		Clock:    clk,
+	}), clk)
+}
+
+func TestKVFollowsClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	kv, err := driver.NewKV(driver.KVOptions{
+		Path:     filepath.Join(t.TempDir(), "sessions.kv"),
+		Lifetime: 10,
+		Clock:    clk,
+	})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	defer func() { _ = kv.Close() }()
+	testDriverFollowsClock(t, kv, clk)
+}
@@ -0,0 +1,61 @@
+package driver_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/driver/drivertest"
+)
+
+func TestFileConformance(t *testing.T) {
+	drivertest.Run(t, func() driver.Driver {
+		return driver.NewFile(filepath.Join(t.TempDir(), "sessions"), 10)
+	})
+}
+
+func TestShardedFileConformance(t *testing.T) {
+	drivertest.Run(t, func() driver.Driver {
+		return driver.NewFileWithOptions(driver.FileOptions{
+			Path:     filepath.Join(t.TempDir(), "sessions"),
+			Lifetime: 10,
+			Layout:   driver.Layout{ShardDepth: 2},
+		})
+	})
+}
+
+func TestKVConformance(t *testing.T) {
+	drivertest.Run(t, func() driver.Driver {
+		kv, err := driver.NewKV(driver.KVOptions{Path: filepath.Join(t.TempDir(), "sessions.kv"), Lifetime: 10})
+		if err != nil {
+			t.Fatalf("NewKV failed: %v", err)
+		}
+		return kv
+	})
+}
+
+func TestFileExpiryConformance(t *testing.T) {
+	drivertest.RunExpiry(t, func(clk clock.Clock, minutes int) driver.Driver {
+		return driver.NewFileWithOptions(driver.FileOptions{
+			Path:     filepath.Join(t.TempDir(), "sessions"),
+			Lifetime: minutes,
+			Layout:   driver.Layout{ShardDepth: 1},
+			Clock:    clk,
+		})
+	})
+}
+
+func TestKVExpiryConformance(t *testing.T) {
+	drivertest.RunExpiry(t, func(clk clock.Clock, minutes int) driver.Driver {
+		kv, err := driver.NewKV(driver.KVOptions{
+			Path:     filepath.Join(t.TempDir(), "sessions.kv"),
+			Lifetime: minutes,
+			Clock:    clk,
+		})
+		if err != nil {
+			t.Fatalf("NewKV failed: %v", err)
+		}
+		return kv
+	})
+}
@@ -1,11 +1,14 @@
 package driver
 
+import "time"
+
 // Driver is the interface for Session handlers.
 //
 // Read and Touch report a missing (or expired) session via their found
 // return value rather than an error: "session is gone" is a normal outcome
 // that callers may treat as a fresh session, while a non-nil error means the
-// store itself failed and stored data must not be overwritten.
+// store itself failed and stored data must not be overwritten. The
+// drivertest package checks an implementation against this contract.
 type Driver interface {
 	// Close closes the session handler.
 	Close() error
@@ -19,9 +22,28 @@ type Driver interface {
 	// failures.
 	Read(id string) (data string, found bool, err error)
 	// Touch refreshes the session's last access time without reading or
-	// writing data. found is false when the session does not exist; err is
-	// reserved for store failures.
+	// writing data. found is false when the session does not exist or has
+	// expired, in which case it must not be revived; err is reserved for
+	// store failures.
 	Touch(id string) (found bool, err error)
 	// Write writes the session data associated with the given ID.
 	Write(id string, data string) error
 }
+
+// Lister is an optional interface for drivers that can enumerate their
+// sessions, which admin tools and "active devices" pages build on.
+type Lister interface {
+	// List calls fn with the ID and data of every stored, unexpired session
+	// until fn returns false.
+	List(fn func(id string, data string) bool) error
+}
+
+// ActivityReporter is an optional interface for drivers that can tell when
+// a session was last written or touched, so its remaining idle time can be
+// reported without refreshing it.
+type ActivityReporter interface {
+	// LastActivity returns the time of the latest Write or Touch of the
+	// session. found is false when the session does not exist or has
+	// expired; err is reserved for store failures.
+	LastActivity(id string) (at time.Time, found bool, err error)
+}
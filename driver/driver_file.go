@@ -1,44 +1,145 @@
 package driver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/sessionid"
 )
 
 const (
-	sessionIDLength = 32
-	tmpSuffix       = ".tmp"
+	tmpSuffix = ".tmp"
+
+	// maxShardDepth bounds Layout.ShardDepth: two levels already spread
+	// sessions over 65536 directories.
+	maxShardDepth = 2
+	// dirReadBatch is how many directory entries Gc and List hold in memory
+	// at once while streaming through a directory.
+	dirReadBatch = 1024
 )
 
+// ErrInvalidSessionID is returned by the File and KV drivers' Write for an
+// ID their ID generator does not accept.
+var ErrInvalidSessionID = errors.New("invalid session id")
+
+// Layout controls how the file driver arranges session files on disk.
+type Layout struct {
+	// ShardDepth is the number of nested subdirectory levels sessions are
+	// spread over. Each level is named by two hex characters of a hash of
+	// the session ID, giving 256 directories per level, so custom ID formats
+	// shard evenly too. 0 stores all sessions flat in one directory; values
+	// above 2 are capped at 2.
+	//
+	// Changing ShardDepth does not move existing sessions: files written
+	// under the old layout are no longer found, so their users start new
+	// sessions. Flat files left in the root directory by an unsharded store
+	// are removed by Gc once they expire.
+	ShardDepth int
+	// GcBatch is the number of shard directories each Gc call scans,
+	// resuming where the previous call stopped, so a single tick never walks
+	// the whole store. 0 scans every shard on each call. Ignored when
+	// ShardDepth is 0.
+	//
+	// A full pass takes 256^ShardDepth / GcBatch calls: with ShardDepth 2
+	// and a GcBatch of 64, that is 1024 ticks, about three weeks at the
+	// default 30-minute GcInterval. Expired sessions are never served in the
+	// meantime, but their files occupy the disk until their shard comes up.
+	GcBatch int
+}
+
 // File is a session driver that stores each session in its own file.
 //
 // Concurrent access to the same session is serialized by the Manager's
 // per-session locks, and writes are atomic (temp file + rename), so the
 // driver itself needs no locking.
 type File struct {
-	path    string
-	minutes int
+	path     string
+	minutes  int
+	fileMode os.FileMode
+	layout   Layout
+	sync     bool
+	clock    clock.Clock
+	ids      sessionid.Generator
+
+	gcMu     sync.Mutex
+	gcCursor int // next leaf shard a batched Gc scans
+}
+
+// FileOptions configures a file driver created by NewFileWithOptions.
+type FileOptions struct {
+	// Path is the session directory. Empty defaults to a dedicated per-user
+	// directory inside os.TempDir() ("sessions-<uid>" on Unix, "sessions" on
+	// Windows where the temp directory is per-user already).
+	Path string
+	// Lifetime is the session lifetime in minutes; sessions older than that
+	// are treated as expired. Defaults to 120.
+	Lifetime int
+	// FileMode is the permission of session files. Defaults to 0600; group
+	// or other access is only sensible for a store shared between users of
+	// the same group, and the directory itself is still required to be 0700.
+	FileMode os.FileMode
+	// Layout arranges sessions in hashed subdirectories; the zero value
+	// stores them flat.
+	Layout Layout
+	// Sync makes Write durable: the session file is fsynced before it is
+	// renamed into place and the directory after, so a Write that returned
+	// nil survives a power loss. It costs two fsyncs per write.
+	Sync bool
+	// Clock decides expiry and stamps written and touched files. Defaults to
+	// clock.System; pass the Manager's clock when it is a fake one.
+	Clock clock.Clock
+	// IDGenerator validates session IDs, which become file names: Write
+	// rejects IDs it does not accept, Read, Touch and Destroy treat them as
+	// missing, and Gc only removes files named by valid IDs. It must match
+	// ManagerOptions.IDGenerator. Defaults to sessionid.Default.
+	IDGenerator sessionid.Generator
 }
 
 // NewFile creates a file driver that stores sessions under path, treating
-// sessions older than minutes as expired. An empty path defaults to a
-// dedicated per-user directory inside os.TempDir() ("sessions-<uid>" on
-// Unix, "sessions" on Windows where the temp directory is per-user already);
-// minutes <= 0 defaults to 120.
+// sessions older than minutes as expired. It is shorthand for
+// NewFileWithOptions with only Path and Lifetime set.
 func NewFile(path string, minutes int) *File {
-	if path == "" {
-		path = filepath.Join(os.TempDir(), defaultDirName())
+	return NewFileWithOptions(FileOptions{Path: path, Lifetime: minutes})
+}
+
+// NewFileWithOptions creates a file driver configured by options.
+func NewFileWithOptions(options FileOptions) *File {
+	if options.Path == "" {
+		options.Path = filepath.Join(os.TempDir(), defaultDirName())
 	}
-	if minutes <= 0 {
-		minutes = 120
+	if options.Lifetime <= 0 {
+		options.Lifetime = 120
+	}
+	if options.FileMode == 0 {
+		options.FileMode = 0o600
+	}
+	if options.Clock == nil {
+		options.Clock = clock.System
+	}
+	if options.IDGenerator == nil {
+		options.IDGenerator = sessionid.Default
 	}
 	return &File{
-		path:    path,
-		minutes: minutes,
+		path:     options.Path,
+		minutes:  options.Lifetime,
+		fileMode: options.FileMode.Perm(),
+		layout: Layout{
+			ShardDepth: min(max(options.Layout.ShardDepth, 0), maxShardDepth),
+			GcBatch:    max(options.Layout.GcBatch, 0),
+		},
+		sync:  options.Sync,
+		clock: options.Clock,
+		ids:   options.IDGenerator,
 	}
 }
 
@@ -47,7 +148,10 @@ func (f *File) Close() error {
 }
 
 func (f *File) Destroy(id string) error {
-	exists, err := f.trustDir()
+	if !f.ids.Valid(id) {
+		return nil
+	}
+	exists, err := f.trustPath(id)
 	if err != nil || !exists {
 		return err
 	}
@@ -58,50 +162,94 @@ func (f *File) Destroy(id string) error {
 }
 
 // Gc removes expired session files. Only files that look like session data
-// (32 alphanumeric characters, or leftover temp files from atomic writes)
-// are removed, so a directory shared with other applications stays intact.
+// (valid session IDs, or leftover temp files from atomic writes) are
+// removed, so a directory shared with other applications stays intact.
+// With a sharded layout and a GcBatch, each call only scans the next batch
+// of shard directories; the root directory is scanned for flat files left
+// from an unsharded layout whenever a pass over the shards begins.
 func (f *File) Gc(maxLifetime int) error {
 	exists, err := f.trustDir()
 	if err != nil || !exists {
 		return err
 	}
 
-	entries, err := os.ReadDir(f.path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
+	cutoff := f.clock.Now().Add(-time.Duration(maxLifetime) * time.Second)
+	if f.layout.ShardDepth == 0 {
+		return f.gcDir(f.path, cutoff)
 	}
 
-	cutoff := time.Now().Add(-time.Duration(maxLifetime) * time.Second)
+	shards := f.shardCount()
+	batch := f.layout.GcBatch
+	if batch <= 0 || batch > shards {
+		batch = shards
+	}
+	f.gcMu.Lock()
+	start := f.gcCursor
+	f.gcCursor = (start + batch) % shards
+	f.gcMu.Unlock()
 
 	var errs []error
-	for _, entry := range entries {
-		if entry.IsDir() || !isSessionFileName(entry.Name()) {
+	if start == 0 {
+		errs = append(errs, f.gcDir(f.path, cutoff))
+	}
+	for i := range batch {
+		dir, exists, err := f.trustShard((start + i) % shards)
+		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
+		if exists {
+			errs = append(errs, f.gcDir(dir, cutoff))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// gcDir removes the expired session files in dir, streaming through its
+// entries in batches rather than loading the whole listing at once.
+func (f *File) gcDir(dir string, cutoff time.Time) error {
+	var errs []error
+	err := walkDir(dir, func(entry os.DirEntry) bool {
+		if entry.IsDir() || !f.isSessionFileName(entry.Name()) {
+			return true
+		}
 		info, err := entry.Info()
 		if err != nil {
-			continue
+			return true
 		}
 		if info.ModTime().Before(cutoff) {
-			if err = os.Remove(filepath.Join(f.path, entry.Name())); err != nil && !os.IsNotExist(err) {
+			if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
 				errs = append(errs, err)
 			}
 		}
-	}
-	return errors.Join(errs...)
+		return true
+	})
+	return errors.Join(append(errs, err)...)
 }
 
 func (f *File) Touch(id string) (bool, error) {
-	exists, err := f.trustDir()
+	if !f.ids.Valid(id) {
+		return false, nil
+	}
+	exists, err := f.trustPath(id)
 	if err != nil || !exists {
 		return false, err
 	}
 
-	now := time.Now()
-	if err = os.Chtimes(f.getFilePath(id), now, now); err != nil {
+	// An expired file awaiting Gc must not be revived
+	path := f.getFilePath(id)
+	now := f.clock.Now()
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if info.IsDir() || !info.ModTime().After(now.Add(-time.Duration(f.minutes)*time.Minute)) {
+		return false, nil
+	}
+	if err = os.Chtimes(path, now, now); err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
@@ -110,13 +258,43 @@ func (f *File) Touch(id string) (bool, error) {
 	return true, nil
 }
 
+// LastActivity returns the modification time of the session file, which
+// Write and Touch set.
+func (f *File) LastActivity(id string) (time.Time, bool, error) {
+	if !f.ids.Valid(id) {
+		return time.Time{}, false, nil
+	}
+	exists, err := f.trustPath(id)
+	if err != nil || !exists {
+		return time.Time{}, false, err
+	}
+	info, err := os.Stat(f.getFilePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	if info.IsDir() || !info.ModTime().After(f.clock.Now().Add(-time.Duration(f.minutes)*time.Minute)) {
+		return time.Time{}, false, nil
+	}
+	return info.ModTime(), true, nil
+}
+
 func (f *File) Read(id string) (string, bool, error) {
-	exists, err := f.trustDir()
+	if !f.ids.Valid(id) {
+		return "", false, nil
+	}
+	exists, err := f.trustPath(id)
 	if err != nil || !exists {
 		return "", false, err
 	}
+	return f.readFile(f.getFilePath(id))
+}
 
-	path := f.getFilePath(id)
+// readFile reads a session file, reporting an expired or missing one as not
+// found. The caller has verified the directory.
+func (f *File) readFile(path string) (string, bool, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -125,7 +303,7 @@ func (f *File) Read(id string) (string, bool, error) {
 		return "", false, err
 	}
 	if info.IsDir() ||
-		!info.ModTime().After(time.Now().Add(-time.Duration(f.minutes)*time.Minute)) {
+		!info.ModTime().After(f.clock.Now().Add(-time.Duration(f.minutes)*time.Minute)) {
 		return "", false, nil
 	}
 
@@ -139,19 +317,76 @@ func (f *File) Read(id string) (string, bool, error) {
 	return string(data), true, nil
 }
 
+// List enumerates the unexpired sessions in the directory. Sessions removed
+// or expiring while the walk is in progress are skipped.
+func (f *File) List(fn func(id string, data string) bool) error {
+	exists, err := f.trustDir()
+	if err != nil || !exists {
+		return err
+	}
+
+	if f.layout.ShardDepth == 0 {
+		_, err = f.listDir(f.path, fn)
+		return err
+	}
+	for i := range f.shardCount() {
+		dir, exists, err := f.trustShard(i)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if more, err := f.listDir(dir, fn); err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDir calls fn for each unexpired session in dir and reports whether the
+// enumeration should continue.
+func (f *File) listDir(dir string, fn func(id string, data string) bool) (bool, error) {
+	more := true
+	var readErr error
+	err := walkDir(dir, func(entry os.DirEntry) bool {
+		if entry.IsDir() || !f.ids.Valid(entry.Name()) {
+			return true
+		}
+		data, found, err := f.readFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			readErr = err
+			return false
+		}
+		if found && !fn(entry.Name(), data) {
+			more = false
+		}
+		return more
+	})
+	if readErr != nil {
+		return false, readErr
+	}
+	return more, err
+}
+
 // Write persists the session data atomically: the data is written to a
-// temporary file (0600) which is then renamed over the target, so a
-// concurrent Read never observes a partially written session.
+// temporary file, created with FileOptions.FileMode, which is then renamed
+// over the target, so a concurrent Read never observes a partially written
+// session.
 func (f *File) Write(id string, data string) error {
-	if err := f.ensureDir(); err != nil {
+	if !f.ids.Valid(id) {
+		return fmt.Errorf("%w: [%s]", ErrInvalidSessionID, id)
+	}
+	dir, err := f.ensureDir(id)
+	if err != nil {
 		return err
 	}
 
-	tmp, err := os.CreateTemp(f.path, id+"-*"+tmpSuffix)
+	tmp, err := os.CreateTemp(dir, id+"-*"+tmpSuffix)
 	if err != nil {
 		return err
 	}
-	if _, err = tmp.WriteString(data); err != nil {
+	if err = f.writeTemp(tmp, data); err != nil {
 		_ = tmp.Close()
 		_ = os.Remove(tmp.Name())
 		return err
@@ -160,17 +395,70 @@ func (f *File) Write(id string, data string) error {
 		_ = os.Remove(tmp.Name())
 		return err
 	}
-	return os.Rename(tmp.Name(), f.getFilePath(id))
+	if err = os.Rename(tmp.Name(), f.getFilePath(id)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if f.sync {
+		return syncDir(dir)
+	}
+	return nil
 }
 
-// ensureDir creates the session directory if needed and verifies it is
-// trustworthy.
-func (f *File) ensureDir() error {
-	if err := os.MkdirAll(f.path, 0o700); err != nil {
+// writeTemp fills the temp file of an atomic write, applying the configured
+// file mode and, in durable mode, flushing it to stable storage.
+func (f *File) writeTemp(tmp *os.File, data string) error {
+	// CreateTemp already uses 0600
+	if f.fileMode != 0o600 {
+		if err := tmp.Chmod(f.fileMode); err != nil {
+			return err
+		}
+	}
+	if _, err := tmp.WriteString(data); err != nil {
 		return err
 	}
-	_, err := f.trustDir()
-	return err
+	// Expiry is judged by mtime, which must come from the same clock
+	if f.clock != clock.System {
+		now := f.clock.Now()
+		if err := os.Chtimes(tmp.Name(), now, now); err != nil {
+			return err
+		}
+	}
+	if f.sync {
+		return tmp.Sync()
+	}
+	return nil
+}
+
+// ensureDir creates the directory holding the session id, including its
+// shard directories, if needed and verifies each level is trustworthy. It
+// returns the directory.
+func (f *File) ensureDir(id string) (string, error) {
+	dir := f.path
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if _, err := f.trustDir(); err != nil {
+		return "", err
+	}
+	for _, shard := range f.shardNames(id) {
+		parent := dir
+		dir = filepath.Join(dir, shard)
+		err := os.Mkdir(dir, 0o700)
+		if err != nil && !os.IsExist(err) {
+			return "", err
+		}
+		if _, err := trustDirAt(dir); err != nil {
+			return "", err
+		}
+		// A freshly created shard is only durable once its parent is synced
+		if err == nil && f.sync {
+			if err = syncDir(parent); err != nil {
+				return "", err
+			}
+		}
+	}
+	return dir, nil
 }
 
 // trustDir verifies that the session directory, when it exists, is a real,
@@ -181,7 +469,43 @@ func (f *File) ensureDir() error {
 // rejected by every operation, not just writes. It returns whether the
 // directory exists.
 func (f *File) trustDir() (bool, error) {
-	info, err := os.Lstat(f.path)
+	return trustDirAt(f.path)
+}
+
+// trustPath verifies the session directory and the shard directories
+// holding the session id. It returns whether all of them exist.
+func (f *File) trustPath(id string) (bool, error) {
+	exists, err := f.trustDir()
+	if err != nil || !exists {
+		return false, err
+	}
+	dir := f.path
+	for _, shard := range f.shardNames(id) {
+		dir = filepath.Join(dir, shard)
+		if exists, err = trustDirAt(dir); err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// trustShard verifies the chain of directories leading to the leaf shard
+// with the given index and returns the leaf directory and whether it exists.
+func (f *File) trustShard(index int) (string, bool, error) {
+	dir := f.path
+	for level := range f.layout.ShardDepth {
+		shift := 8 * (f.layout.ShardDepth - 1 - level)
+		dir = filepath.Join(dir, fmt.Sprintf("%02x", (index>>shift)&0xff))
+		if exists, err := trustDirAt(dir); err != nil || !exists {
+			return dir, false, err
+		}
+	}
+	return dir, true, nil
+}
+
+// trustDirAt applies the trustDir checks to path.
+func trustDirAt(path string) (bool, error) {
+	info, err := os.Lstat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -189,40 +513,91 @@ func (f *File) trustDir() (bool, error) {
 		return false, err
 	}
 	if !info.IsDir() {
-		return true, fmt.Errorf("session path [%s] is not a directory", f.path)
+		return true, fmt.Errorf("session path [%s] is not a directory", path)
 	}
-	return true, checkDirTrusted(f.path, info)
+	return true, checkDirTrusted(path, info)
+}
+
+// shardCount returns the number of leaf shard directories of the layout.
+func (f *File) shardCount() int {
+	return 1 << (8 * f.layout.ShardDepth)
+}
+
+// shardNames returns the shard directory names for id, outermost first.
+func (f *File) shardNames(id string) []string {
+	if f.layout.ShardDepth == 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(id))
+	names := make([]string, f.layout.ShardDepth)
+	for level := range names {
+		names[level] = hex.EncodeToString(sum[level : level+1])
+	}
+	return names
 }
 
 func (f *File) getFilePath(id string) string {
 	// Base guards against path traversal if the driver is used directly
 	// with an unvalidated ID.
-	return filepath.Join(f.path, filepath.Base(id))
+	return filepath.Join(append(append([]string{f.path}, f.shardNames(id)...), filepath.Base(id))...)
 }
 
-func isValidSessionID(id string) bool {
-	if len(id) != sessionIDLength {
-		return false
+// syncDir flushes a directory's entries (a rename or a new subdirectory) to
+// stable storage. Windows cannot sync directories and persists renames with
+// the file metadata, so it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
 	}
-	for i := 0; i < len(id); i++ {
-		c := id[i]
-		if (c < '0' || c > '9') &&
-			(c < 'A' || c > 'Z') &&
-			(c < 'a' || c > 'z') {
-			return false
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// walkDir calls fn for each entry of dir, reading the listing in batches,
+// until fn returns false. A missing directory has no entries.
+func walkDir(dir string, fn func(entry os.DirEntry) bool) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = d.Close() }()
+
+	for {
+		entries, err := d.ReadDir(dirReadBatch)
+		for _, entry := range entries {
+			if !fn(entry) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
-	return true
 }
 
-// isSessionFileName reports whether name is a session file ("<32 alnum>")
-// or a leftover temp file from an interrupted atomic write ("<32 alnum>-<random>.tmp").
-func isSessionFileName(name string) bool {
-	if isValidSessionID(name) {
+// isSessionFileName reports whether name is a session file ("<id>") or a
+// leftover temp file from an interrupted atomic write ("<id>-<random>.tmp").
+func (f *File) isSessionFileName(name string) bool {
+	if f.ids.Valid(name) {
 		return true
 	}
-	return strings.HasSuffix(name, tmpSuffix) &&
-		len(name) > sessionIDLength+len(tmpSuffix) &&
-		name[sessionIDLength] == '-' &&
-		isValidSessionID(name[:sessionIDLength])
+	base, ok := strings.CutSuffix(name, tmpSuffix)
+	if !ok {
+		return false
+	}
+	i := strings.LastIndexByte(base, '-')
+	return i > 0 && f.ids.Valid(base[:i])
 }
@@ -1,12 +1,15 @@
 package driver
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/libtnb/sessions/sessionid"
 )
 
 const testID = "12345678901234567890123456789012"
@@ -95,7 +98,7 @@ func TestFileTouchRefreshesTimestamp(t *testing.T) {
 		t.Fatalf("Write failed: %v", err)
 	}
 	path := filepath.Join(dir, testID)
-	old := time.Now().Add(-time.Hour)
+	old := time.Now().Add(-5 * time.Minute)
 	if err := os.Chtimes(path, old, old); err != nil {
 		t.Fatalf("Chtimes failed: %v", err)
 	}
@@ -108,7 +111,7 @@ func TestFileTouchRefreshesTimestamp(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Stat failed: %v", err)
 	}
-	if !info.ModTime().After(time.Now().Add(-time.Minute)) {
+	if !info.ModTime().After(old.Add(time.Minute)) {
 		t.Fatalf("Touch did not refresh mtime, got %v", info.ModTime())
 	}
 }
@@ -121,6 +124,25 @@ func TestFileTouchMissingReportsNotFound(t *testing.T) {
 	}
 }
 
+func TestFileTouchExpiredReportsNotFound(t *testing.T) {
+	f, dir := newTestFile(t, 10)
+
+	if err := f.Write(testID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, testID), old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	if found, err := f.Touch(testID); found || err != nil {
+		t.Fatalf("Touch of expired session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+	if _, found, _ := f.Read(testID); found {
+		t.Fatal("Touch revived an expired session")
+	}
+}
+
 func TestFileDestroyMissingIsNoop(t *testing.T) {
 	f, _ := newTestFile(t, 10)
 
@@ -279,3 +301,246 @@ func TestFileWriteRejectsPermissiveDir(t *testing.T) {
 		t.Fatal("expected Write to reject a group/other-accessible session directory")
 	}
 }
+
+func TestFileListSkipsExpiredAndForeignFiles(t *testing.T) {
+	f, dir := newTestFile(t, 10)
+
+	live := strings.Repeat("a", 32)
+	expired := strings.Repeat("b", 32)
+	for _, id := range []string{live, expired} {
+		if err := f.Write(id, "payload-"+id[:1]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, expired), old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "someone-elses-file.txt"), []byte("x"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	if err := f.List(func(id string, data string) bool {
+		got[id] = data
+		return true
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != 1 || got[live] != "payload-a" {
+		t.Fatalf("List = %v, want only the live session", got)
+	}
+}
+
+func TestShardedFileStoresInHashedSubdirectories(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	f := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10, Layout: Layout{ShardDepth: 2}})
+
+	if err := f.Write(testID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	path := f.getFilePath(testID)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		t.Fatalf("Rel failed: %v", err)
+	}
+	if parts := strings.Split(rel, string(filepath.Separator)); len(parts) != 3 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		t.Fatalf("session stored at %q, want two shard levels", rel)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o700 {
+			t.Fatalf("shard directory mode = %o, want 700", perm)
+		}
+	}
+
+	data, found, err := f.Read(testID)
+	if err != nil || !found || data != "payload" {
+		t.Fatalf("Read = %q found=%v err=%v", data, found, err)
+	}
+	if found, err = f.Touch(testID); err != nil || !found {
+		t.Fatalf("Touch: found=%v err=%v", found, err)
+	}
+
+	got := 0
+	if err = f.List(func(id string, data string) bool {
+		got++
+		return id == testID
+	}); err != nil || got != 1 {
+		t.Fatalf("List saw %d sessions, err=%v", got, err)
+	}
+
+	if err = f.Destroy(testID); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, found, _ = f.Read(testID); found {
+		t.Fatal("session still readable after Destroy")
+	}
+}
+
+func TestShardedFileGcScansInBatches(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	f := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10, Layout: Layout{ShardDepth: 1, GcBatch: 64}})
+
+	old := time.Now().Add(-time.Hour)
+	var ids []string
+	for i := range 32 {
+		id := strings.Repeat(string(rune('a'+i%26)), 31) + string(rune('A'+i/26))
+		if err := f.Write(id, "payload"); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := os.Chtimes(f.getFilePath(id), old, old); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	remaining := func() int {
+		n := 0
+		for _, id := range ids {
+			if _, err := os.Stat(f.getFilePath(id)); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	if err := f.Gc(60); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	if n := remaining(); n == 0 {
+		t.Fatal("a single batched Gc pass scanned every shard")
+	}
+	for range 3 {
+		if err := f.Gc(60); err != nil {
+			t.Fatalf("Gc failed: %v", err)
+		}
+	}
+	if n := remaining(); n != 0 {
+		t.Fatalf("%d expired sessions left after a full Gc cycle", n)
+	}
+}
+
+func TestShardedFileRejectsSymlinkedShard(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	dir := filepath.Join(t.TempDir(), "sessions")
+	f := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10, Layout: Layout{ShardDepth: 1}})
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	target := t.TempDir()
+	if err := os.Symlink(target, filepath.Dir(f.getFilePath(testID))); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	if err := f.Write(testID, "payload"); err == nil {
+		t.Fatal("Write through a symlinked shard succeeded")
+	}
+	if _, _, err := f.Read(testID); err == nil {
+		t.Fatal("Read through a symlinked shard succeeded")
+	}
+}
+
+func TestFileSyncWriteAndFileMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	f := NewFileWithOptions(FileOptions{
+		Path:     dir,
+		Lifetime: 10,
+		FileMode: 0o640,
+		Layout:   Layout{ShardDepth: 1},
+		Sync:     true,
+	})
+
+	if err := f.Write(testID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, found, err := f.Read(testID)
+	if err != nil || !found || data != "payload" {
+		t.Fatalf("Read = %q found=%v err=%v", data, found, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(f.getFilePath(testID))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o640 {
+		t.Fatalf("session file mode = %o, want 640", perm)
+	}
+}
+
+func TestFileValidatesIDsWithGenerator(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	ids := sessionid.UUIDv7()
+	f := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10, IDGenerator: ids})
+
+	id := ids.New()
+	if err := f.Write(id, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, found, err := f.Read(id); err != nil || !found || data != "payload" {
+		t.Fatalf("Read = %q found=%v err=%v", data, found, err)
+	}
+
+	if err := f.Write(testID, "payload"); !errors.Is(err, ErrInvalidSessionID) {
+		t.Fatalf("Write of an ID the generator rejects = %v, want ErrInvalidSessionID", err)
+	}
+	if _, found, err := f.Read("../" + id); found || err != nil {
+		t.Fatalf("Read of an invalid ID: found=%v err=%v, want found=false err=nil", found, err)
+	}
+
+	// Leftover temp files are recognized by the generator's ID format too
+	old := time.Now().Add(-time.Hour)
+	leftover := filepath.Join(dir, ids.New()+"-123456"+tmpSuffix)
+	foreign := filepath.Join(dir, "not-a-session-123456"+tmpSuffix)
+	for _, path := range []string{leftover, foreign} {
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+	if err := f.Gc(600); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatal("Gc kept an expired temp file of a valid ID")
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Fatalf("Gc removed a foreign file: %v", err)
+	}
+}
+
+func TestShardedFileGcRemovesFlatFilesFromOldLayout(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	flat := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10})
+	if err := flat.Write(testID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(flat.getFilePath(testID), old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	const liveID = "abcdefghijklmnopqrstuvwxyzABCDEF"
+	if err := flat.Write(liveID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	sharded := NewFileWithOptions(FileOptions{Path: dir, Lifetime: 10, Layout: Layout{ShardDepth: 1, GcBatch: 16}})
+	if err := sharded.Gc(60); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, testID)); !os.IsNotExist(err) {
+		t.Fatalf("expired flat session file survived Gc: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, liveID)); err != nil {
+		t.Fatalf("unexpired flat session file was removed: %v", err)
+	}
+}
@@ -0,0 +1,549 @@
+package driver
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/sessionid"
+)
+
+// Record layout of the KV log, little endian:
+//
+//	crc32   uint32  checksum of everything after it
+//	op      uint8   kvOpWrite, kvOpTouch or kvOpDestroy
+//	at      int64   last activity, Unix nanoseconds
+//	idLen   uint16
+//	dataLen uint32
+//	id      [idLen]byte
+//	data    [dataLen]byte  (kvOpWrite only)
+const kvHeaderSize = 4 + 1 + 8 + 2 + 4
+
+const (
+	kvOpWrite byte = iota + 1
+	kvOpTouch
+	kvOpDestroy
+)
+
+// kvCompactMinSize is the log size below which Gc never compacts; rewriting
+// a small file gains nothing.
+const kvCompactMinSize = 1 << 20
+
+// ErrKVClosed is returned by every KV operation after Close.
+var ErrKVClosed = errors.New("kv store is closed")
+
+// KVOptions configures a KV driver created by NewKV.
+type KVOptions struct {
+	// Path is the database file. It is created 0600 if missing; its
+	// directory is created 0700. Required.
+	Path string
+	// Lifetime is the session lifetime in minutes; sessions idle for longer
+	// are treated as expired. Defaults to 120.
+	Lifetime int
+	// Sync fsyncs the log after every change, so a Write that returned nil
+	// survives a power loss. Without it a crash may lose the most recent
+	// changes, but never corrupts older ones.
+	Sync bool
+	// Clock decides expiry and stamps activity. Defaults to clock.System;
+	// pass the Manager's clock when it is a fake one.
+	Clock clock.Clock
+	// IDGenerator validates session IDs: Write rejects IDs it does not
+	// accept, which then read as missing. It must match
+	// ManagerOptions.IDGenerator. Defaults to sessionid.Default.
+	IDGenerator sessionid.Generator
+}
+
+// kvEntry is the in-memory index entry of a stored session.
+type kvEntry struct {
+	id     string
+	offset int64 // offset of the data in the log
+	size   int   // length of the data
+	record int64 // size of the write record holding the data
+	at     int64 // last activity, Unix nanoseconds
+	elem   *list.Element
+}
+
+// KV is a session driver that keeps every session in a single append-only
+// log file, for appliances where thousands of small files are undesirable.
+//
+// An in-memory index maps IDs to their latest data in the log, and a list
+// ordered by last activity makes Gc a scan of the expired prefix rather
+// than a walk of every session. Each record is checksummed: a torn record
+// left by a crash is detected and cut off when the file is reopened. Gc
+// compacts the log once most of it is superseded, rewriting the live
+// sessions to a new file that atomically replaces the old one.
+type KV struct {
+	mu       sync.RWMutex
+	file     *os.File
+	path     string
+	minutes  int
+	sync     bool
+	clock    clock.Clock
+	ids      sessionid.Generator
+	index    map[string]*kvEntry
+	activity *list.List // *kvEntry, least recently active first
+	size     int64      // end of the log, where the next record goes
+	live     int64      // bytes of the log still referenced by the index
+}
+
+// NewKV opens, or creates, the KV database at options.Path and loads its
+// index.
+func NewKV(options KVOptions) (*KV, error) {
+	if options.Path == "" {
+		return nil, errors.New("kv path is required")
+	}
+	if options.Lifetime <= 0 {
+		options.Lifetime = 120
+	}
+	if options.Clock == nil {
+		options.Clock = clock.System
+	}
+	if options.IDGenerator == nil {
+		options.IDGenerator = sessionid.Default
+	}
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := openKVFile(options.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := &KV{
+		file:     file,
+		path:     options.Path,
+		minutes:  options.Lifetime,
+		sync:     options.Sync,
+		clock:    options.Clock,
+		ids:      options.IDGenerator,
+		index:    make(map[string]*kvEntry),
+		activity: list.New(),
+	}
+	if err = kv.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return kv, nil
+}
+
+// openKVFile opens the database file, rejecting a symlink or a file that
+// other users could read or swap, for the same reasons the file driver
+// checks its directory.
+func openKVFile(path string) (*os.File, error) {
+	info, err := os.Lstat(path)
+	if err == nil && !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("session path [%s] is not a regular file", path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := file.Stat()
+	if err == nil && info != nil && !os.SameFile(info, opened) {
+		err = fmt.Errorf("session path [%s] was replaced while opening", path)
+	}
+	if err == nil {
+		err = checkDirTrusted(path, opened)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (kv *KV) Close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.file == nil {
+		return nil
+	}
+	err := kv.file.Close()
+	kv.file = nil
+	return err
+}
+
+func (kv *KV) Destroy(id string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.file == nil {
+		return ErrKVClosed
+	}
+	entry, ok := kv.index[id]
+	if !ok {
+		return nil
+	}
+	if _, err := kv.append(kvOpDestroy, id, entry.at, ""); err != nil {
+		return err
+	}
+	if err := kv.flush(); err != nil {
+		return err
+	}
+	kv.remove(entry)
+	return nil
+}
+
+// Gc destroys the sessions idle for longer than maxLifetime seconds and
+// compacts the log when most of it is superseded.
+func (kv *KV) Gc(maxLifetime int) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.file == nil {
+		return ErrKVClosed
+	}
+	cutoff := kv.clock.Now().Add(-time.Duration(maxLifetime) * time.Second).UnixNano()
+
+	expired := false
+	for elem := kv.activity.Front(); elem != nil; {
+		entry := elem.Value.(*kvEntry)
+		if entry.at >= cutoff {
+			break
+		}
+		elem = elem.Next()
+		if _, err := kv.append(kvOpDestroy, entry.id, entry.at, ""); err != nil {
+			return err
+		}
+		kv.remove(entry)
+		expired = true
+	}
+	if expired {
+		if err := kv.flush(); err != nil {
+			return err
+		}
+	}
+
+	if kv.size >= kvCompactMinSize && kv.size-kv.live > kv.live {
+		return kv.compact()
+	}
+	return nil
+}
+
+func (kv *KV) Read(id string) (string, bool, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.file == nil {
+		return "", false, ErrKVClosed
+	}
+	entry, ok := kv.index[id]
+	if !ok || kv.expired(entry) {
+		return "", false, nil
+	}
+	data, err := kv.readData(entry)
+	if err != nil {
+		return "", false, err
+	}
+	return data, true, nil
+}
+
+func (kv *KV) Touch(id string) (bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.file == nil {
+		return false, ErrKVClosed
+	}
+	entry, ok := kv.index[id]
+	if !ok || kv.expired(entry) {
+		return false, nil
+	}
+	now := kv.clock.Now().UnixNano()
+	if _, err := kv.append(kvOpTouch, id, now, ""); err != nil {
+		return false, err
+	}
+	if err := kv.flush(); err != nil {
+		return false, err
+	}
+	entry.at = now
+	kv.activity.MoveToBack(entry.elem)
+	return true, nil
+}
+
+// LastActivity returns the time of the session's latest write or touch,
+// from the in-memory index.
+func (kv *KV) LastActivity(id string) (time.Time, bool, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.file == nil {
+		return time.Time{}, false, ErrKVClosed
+	}
+	entry, ok := kv.index[id]
+	if !ok || kv.expired(entry) {
+		return time.Time{}, false, nil
+	}
+	return time.Unix(0, entry.at), true, nil
+}
+
+func (kv *KV) Write(id string, data string) error {
+	if !kv.ids.Valid(id) {
+		return fmt.Errorf("%w: [%s]", ErrInvalidSessionID, id)
+	}
+	if len(id) > math.MaxUint16 || int64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("session [%s] too large for the kv store", id)
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.file == nil {
+		return ErrKVClosed
+	}
+	now := kv.clock.Now().UnixNano()
+	record, err := kv.append(kvOpWrite, id, now, data)
+	if err != nil {
+		return err
+	}
+	if err = kv.flush(); err != nil {
+		return err
+	}
+	kv.put(id, now, kv.size-int64(len(data)), len(data), record)
+	return nil
+}
+
+// List enumerates the unexpired sessions, least recently active first. The
+// sessions are collected before fn is called, so fn may use the driver.
+func (kv *KV) List(fn func(id string, data string) bool) error {
+	type item struct{ id, data string }
+
+	kv.mu.RLock()
+	if kv.file == nil {
+		kv.mu.RUnlock()
+		return ErrKVClosed
+	}
+	items := make([]item, 0, len(kv.index))
+	for elem := kv.activity.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*kvEntry)
+		if kv.expired(entry) {
+			continue
+		}
+		data, err := kv.readData(entry)
+		if err != nil {
+			kv.mu.RUnlock()
+			return err
+		}
+		items = append(items, item{entry.id, data})
+	}
+	kv.mu.RUnlock()
+
+	for _, it := range items {
+		if !fn(it.id, it.data) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// load replays the log into the index. Replay stops at the first record
+// that is incomplete or fails its checksum, which is what a crash during an
+// append leaves behind, and the log is truncated there so later appends
+// start from a clean end.
+func (kv *KV) load() error {
+	info, err := kv.file.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	r := bufio.NewReader(io.NewSectionReader(kv.file, 0, end))
+
+	var offset int64
+	header := make([]byte, kvHeaderSize)
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			break
+		}
+		op, at, idLen, dataLen := decodeKVHeader(header)
+		record := int64(kvHeaderSize) + int64(idLen) + int64(dataLen)
+		if offset+record > end {
+			break
+		}
+		body := make([]byte, int(idLen)+int(dataLen))
+		if _, err = io.ReadFull(r, body); err != nil {
+			break
+		}
+		crc := crc32.NewIEEE()
+		_, _ = crc.Write(header[4:])
+		_, _ = crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header) {
+			break
+		}
+
+		id := string(body[:idLen])
+		switch op {
+		case kvOpWrite:
+			kv.put(id, at, offset+kvHeaderSize+int64(idLen), int(dataLen), record)
+		case kvOpTouch:
+			if entry, ok := kv.index[id]; ok {
+				entry.at = at
+				kv.activity.MoveToBack(entry.elem)
+			}
+		case kvOpDestroy:
+			if entry, ok := kv.index[id]; ok {
+				kv.remove(entry)
+			}
+		}
+		offset += record
+	}
+
+	kv.size = offset
+	if offset < end {
+		return kv.file.Truncate(offset)
+	}
+	return nil
+}
+
+// append writes a record at the end of the log and returns its size. A
+// failed append is cut off again so it cannot hide later records.
+func (kv *KV) append(op byte, id string, at int64, data string) (int64, error) {
+	record := encodeKVRecord(op, id, at, data)
+	if _, err := kv.file.WriteAt(record, kv.size); err != nil {
+		_ = kv.file.Truncate(kv.size)
+		return 0, err
+	}
+	kv.size += int64(len(record))
+	return int64(len(record)), nil
+}
+
+// flush makes the appended records durable in sync mode.
+func (kv *KV) flush() error {
+	if !kv.sync {
+		return nil
+	}
+	return kv.file.Sync()
+}
+
+// compact rewrites the live sessions, in activity order, to a new log that
+// atomically replaces the current one. The new log is always fsynced before
+// the rename, whatever the Sync option, because losing it would lose every
+// session rather than the latest change.
+func (kv *KV) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(kv.path), filepath.Base(kv.path)+"-*"+tmpSuffix)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	type placement struct {
+		entry  *kvEntry
+		offset int64
+		record int64
+	}
+	placements := make([]placement, 0, len(kv.index))
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for elem := kv.activity.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*kvEntry)
+		data, err := kv.readData(entry)
+		if err != nil {
+			return fail(err)
+		}
+		record := encodeKVRecord(kvOpWrite, entry.id, entry.at, data)
+		if _, err = w.Write(record); err != nil {
+			return fail(err)
+		}
+		placements = append(placements, placement{
+			entry:  entry,
+			offset: size + kvHeaderSize + int64(len(entry.id)),
+			record: int64(len(record)),
+		})
+		size += int64(len(record))
+	}
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fail(err)
+	}
+
+	// Windows cannot rename over an open file, so the old log is closed
+	// first and reopened should the rename fail.
+	if err = kv.file.Close(); err != nil {
+		return fail(err)
+	}
+	if err = os.Rename(tmp.Name(), kv.path); err != nil {
+		var reopenErr error
+		kv.file, reopenErr = os.OpenFile(kv.path, os.O_RDWR, 0)
+		return errors.Join(fail(err), reopenErr)
+	}
+	// The new log is in place: point the index at it before anything else
+	// can fail, so reads and appends never use offsets into the old one.
+	kv.file = tmp
+	for _, p := range placements {
+		p.entry.offset, p.entry.record = p.offset, p.record
+	}
+	kv.size, kv.live = size, size
+	return syncDir(filepath.Dir(kv.path))
+}
+
+// put points the index at a newly written record of id.
+func (kv *KV) put(id string, at, offset int64, size int, record int64) {
+	entry, ok := kv.index[id]
+	if ok {
+		kv.live -= entry.record
+		kv.activity.MoveToBack(entry.elem)
+	} else {
+		entry = &kvEntry{id: id}
+		entry.elem = kv.activity.PushBack(entry)
+		kv.index[id] = entry
+	}
+	entry.at, entry.offset, entry.size, entry.record = at, offset, size, record
+	kv.live += record
+}
+
+func (kv *KV) remove(entry *kvEntry) {
+	kv.activity.Remove(entry.elem)
+	delete(kv.index, entry.id)
+	kv.live -= entry.record
+}
+
+func (kv *KV) expired(entry *kvEntry) bool {
+	return entry.at <= kv.clock.Now().Add(-time.Duration(kv.minutes)*time.Minute).UnixNano()
+}
+
+func (kv *KV) readData(entry *kvEntry) (string, error) {
+	data := make([]byte, entry.size)
+	if _, err := kv.file.ReadAt(data, entry.offset); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func encodeKVRecord(op byte, id string, at int64, data string) []byte {
+	record := make([]byte, kvHeaderSize+len(id)+len(data))
+	record[4] = op
+	binary.LittleEndian.PutUint64(record[5:], uint64(at))
+	binary.LittleEndian.PutUint16(record[13:], uint16(len(id)))
+	binary.LittleEndian.PutUint32(record[15:], uint32(len(data)))
+	copy(record[kvHeaderSize:], id)
+	copy(record[kvHeaderSize+len(id):], data)
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+func decodeKVHeader(header []byte) (op byte, at int64, idLen uint16, dataLen uint32) {
+	return header[4],
+		int64(binary.LittleEndian.Uint64(header[5:])),
+		binary.LittleEndian.Uint16(header[13:]),
+		binary.LittleEndian.Uint32(header[15:])
+}
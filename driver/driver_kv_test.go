@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/sessionid"
+)
+
+func newTestKV(t *testing.T, sync bool) (*KV, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db", "sessions.kv")
+	kv, err := NewKV(KVOptions{Path: path, Lifetime: 10, Sync: sync})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	return kv, path
+}
+
+// age backdates a session's last activity, as if it had been idle.
+func (kv *KV) age(id string, d time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry := kv.index[id]
+	entry.at = time.Now().Add(-d).UnixNano()
+	kv.activity.MoveToFront(entry.elem)
+}
+
+func TestKVPersistsAcrossReopen(t *testing.T) {
+	kv, path := newTestKV(t, true)
+
+	other := strings.Repeat("b", 32)
+	for _, id := range []string{testID, other} {
+		if err := kv.Write(id, "first-"+id[:1]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := kv.Write(testID, "second"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := kv.Destroy(other); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened, err := NewKV(KVOptions{Path: path, Lifetime: 10})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if data, found, err := reopened.Read(testID); err != nil || !found || data != "second" {
+		t.Fatalf("Read = %q found=%v err=%v, want the latest write", data, found, err)
+	}
+	if _, found, _ := reopened.Read(other); found {
+		t.Fatal("destroyed session came back after reopen")
+	}
+}
+
+func TestKVDropsTornTail(t *testing.T) {
+	kv, path := newTestKV(t, false)
+
+	if err := kv.Write(testID, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	_ = kv.Close()
+
+	// A crash in the middle of an append leaves a partial record behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	torn := encodeKVRecord(kvOpWrite, testID, time.Now().UnixNano(), "half-written")
+	_, _ = f.Write(torn[:len(torn)-3])
+	_ = f.Close()
+
+	reopened, err := NewKV(KVOptions{Path: path, Lifetime: 10})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if data, found, err := reopened.Read(testID); err != nil || !found || data != "payload" {
+		t.Fatalf("Read = %q found=%v err=%v, want the last complete write", data, found, err)
+	}
+	if err = reopened.Write(testID, "after"); err != nil {
+		t.Fatalf("Write after recovery failed: %v", err)
+	}
+	_ = reopened.Close()
+
+	again, err := NewKV(KVOptions{Path: path, Lifetime: 10})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	defer func() { _ = again.Close() }()
+	if data, _, _ := again.Read(testID); data != "after" {
+		t.Fatalf("Read = %q, want the write appended after recovery", data)
+	}
+}
+
+func TestKVExpiryAndGc(t *testing.T) {
+	kv, _ := newTestKV(t, false)
+
+	live := strings.Repeat("a", 32)
+	stale := strings.Repeat("b", 32)
+	for _, id := range []string{live, stale} {
+		if err := kv.Write(id, "payload"); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	kv.age(stale, time.Hour)
+
+	if _, found, err := kv.Read(stale); found || err != nil {
+		t.Fatalf("Read of expired session: found=%v err=%v", found, err)
+	}
+	if found, err := kv.Touch(stale); found || err != nil {
+		t.Fatalf("Touch of expired session: found=%v err=%v", found, err)
+	}
+
+	if err := kv.Gc(60); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	if _, ok := kv.index[stale]; ok {
+		t.Fatal("Gc kept the expired session")
+	}
+	if _, ok := kv.index[live]; !ok {
+		t.Fatal("Gc removed a live session")
+	}
+}
+
+func TestKVGcCompactsLog(t *testing.T) {
+	kv, path := newTestKV(t, false)
+
+	payload := strings.Repeat("x", 64<<10)
+	for range 32 {
+		if err := kv.Write(testID, payload); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	before, _ := os.Stat(path)
+	if err := kv.Gc(600); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if after.Size() >= before.Size()/2 {
+		t.Fatalf("log size %d after Gc, want it compacted from %d", after.Size(), before.Size())
+	}
+	if data, found, err := kv.Read(testID); err != nil || !found || data != payload {
+		t.Fatalf("Read after compaction: found=%v err=%v", found, err)
+	}
+	if err = kv.Write(testID, "after"); err != nil {
+		t.Fatalf("Write after compaction failed: %v", err)
+	}
+	if data, _, _ := kv.Read(testID); data != "after" {
+		t.Fatalf("Read = %q, want the write after compaction", data)
+	}
+}
+
+func TestKVClosed(t *testing.T) {
+	kv, _ := newTestKV(t, false)
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("second Close = %v, want nil", err)
+	}
+	if err := kv.Write(testID, "payload"); !errors.Is(err, ErrKVClosed) {
+		t.Fatalf("Write after Close = %v, want ErrKVClosed", err)
+	}
+	if _, _, err := kv.Read(testID); !errors.Is(err, ErrKVClosed) {
+		t.Fatalf("Read after Close = %v, want ErrKVClosed", err)
+	}
+}
+
+func TestKVValidatesIDsWithGenerator(t *testing.T) {
+	ids := sessionid.UUIDv7()
+	kv, err := NewKV(KVOptions{Path: filepath.Join(t.TempDir(), "sessions.kv"), Lifetime: 10, IDGenerator: ids})
+	if err != nil {
+		t.Fatalf("NewKV failed: %v", err)
+	}
+	defer func() { _ = kv.Close() }()
+
+	id := ids.New()
+	if err = kv.Write(id, "payload"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err = kv.Write(testID, "payload"); !errors.Is(err, ErrInvalidSessionID) {
+		t.Fatalf("Write of an ID the generator rejects = %v, want ErrInvalidSessionID", err)
+	}
+	if _, found, err := kv.Read(testID); found || err != nil {
+		t.Fatalf("Read of a rejected ID: found=%v err=%v, want found=false err=nil", found, err)
+	}
+}
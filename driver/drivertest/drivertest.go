@@ -0,0 +1,516 @@
+// Package drivertest checks that a session driver satisfies the
+// driver.Driver contract. Driver authors call Run from a test:
+//
+//	func TestConformance(t *testing.T) {
+//		drivertest.Run(t, func() driver.Driver {
+//			return mydriver.New(...)
+//		})
+//	}
+//
+// Drivers that take a clock.Clock are checked against the expiry contract
+// with RunExpiry, which moves a fake clock past the session lifetime.
+package drivertest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/driver"
+)
+
+// ID is a well-formed session ID (32 alphanumeric characters).
+const ID = "AbCdEfGhIjKlMnOpQrStUvWxYz012345"
+
+// Run exercises the driver.Driver contract against drivers returned by
+// newDriver, which must return a fresh, empty driver on every call with a
+// session lifetime of at least a minute. Drivers that implement
+// driver.Lister or driver.ActivityReporter are checked against those
+// contracts too.
+//
+// The Gc checks wait a little over a second, as Gc takes its lifetime in
+// seconds.
+func Run(t *testing.T, newDriver func() driver.Driver) {
+	t.Helper()
+
+	open := func(t *testing.T) driver.Driver {
+		t.Helper()
+		d := newDriver()
+		if d == nil {
+			t.Fatal("newDriver returned nil")
+		}
+		t.Cleanup(func() { _ = d.Close() })
+		return d
+	}
+
+	t.Run("ReadMissing", func(t *testing.T) { testReadMissing(t, open(t)) })
+	t.Run("WriteRead", func(t *testing.T) { testWriteRead(t, open(t)) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, open(t)) })
+	t.Run("Payloads", func(t *testing.T) { testPayloads(t, open(t)) })
+	t.Run("DistinctIDs", func(t *testing.T) { testDistinctIDs(t, open(t)) })
+	t.Run("Touch", func(t *testing.T) { testTouch(t, open(t)) })
+	t.Run("Destroy", func(t *testing.T) { testDestroy(t, open(t)) })
+	t.Run("Gc", func(t *testing.T) { testGc(t, open(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, open(t)) })
+	t.Run("InvalidIDs", func(t *testing.T) { testInvalidIDs(t, open(t)) })
+	t.Run("Close", func(t *testing.T) { testClose(t, open(t)) })
+	t.Run("List", func(t *testing.T) {
+		d := open(t)
+		lister, ok := d.(driver.Lister)
+		if !ok {
+			t.Skip("driver does not implement driver.Lister")
+		}
+		testList(t, d, lister)
+	})
+	t.Run("LastActivity", func(t *testing.T) {
+		d := open(t)
+		reporter, ok := d.(driver.ActivityReporter)
+		if !ok {
+			t.Skip("driver does not implement driver.ActivityReporter")
+		}
+		testLastActivity(t, d, reporter)
+	})
+}
+
+// Lifetime is the session lifetime, in minutes, RunExpiry creates drivers
+// with.
+const Lifetime = 10
+
+// RunExpiry exercises the expiry contract against drivers returned by
+// newDriver, which must return a fresh, empty driver on every call that
+// takes its time from clk and treats sessions idle for longer than minutes
+// as expired. Expired sessions must read as missing and must not be revived
+// by Touch; drivers that implement driver.Lister or driver.ActivityReporter
+// must not report them either.
+func RunExpiry(t *testing.T, newDriver func(clk clock.Clock, minutes int) driver.Driver) {
+	t.Helper()
+
+	open := func(t *testing.T) (driver.Driver, *clock.Fake) {
+		t.Helper()
+		clk := clock.NewFake(time.Now())
+		d := newDriver(clk, Lifetime)
+		if d == nil {
+			t.Fatal("newDriver returned nil")
+		}
+		t.Cleanup(func() { _ = d.Close() })
+		return d, clk
+	}
+
+	for name, test := range map[string]func(*testing.T, driver.Driver, *clock.Fake){
+		"ReadExpired":  testReadExpired,
+		"TouchExpired": testTouchExpired,
+		"TouchExtends": testTouchExtends,
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, clk := open(t)
+			test(t, d, clk)
+		})
+	}
+	t.Run("ListExpired", func(t *testing.T) {
+		d, clk := open(t)
+		lister, ok := d.(driver.Lister)
+		if !ok {
+			t.Skip("driver does not implement driver.Lister")
+		}
+		testListExpired(t, d, clk, lister)
+	})
+	t.Run("LastActivityExpired", func(t *testing.T) {
+		d, clk := open(t)
+		reporter, ok := d.(driver.ActivityReporter)
+		if !ok {
+			t.Skip("driver does not implement driver.ActivityReporter")
+		}
+		testLastActivityExpired(t, d, clk, reporter)
+	})
+}
+
+// id returns the n-th well-formed session ID.
+func id(n int) string {
+	return fmt.Sprintf("%s%06d", ID[:26], n)
+}
+
+func mustWrite(t *testing.T, d driver.Driver, id, data string) {
+	t.Helper()
+	if err := d.Write(id, data); err != nil {
+		t.Fatalf("Write(%q) failed: %v", id, err)
+	}
+}
+
+func expectData(t *testing.T, d driver.Driver, id, want string) {
+	t.Helper()
+	data, found, err := d.Read(id)
+	if err != nil {
+		t.Fatalf("Read(%q) failed: %v", id, err)
+	}
+	if !found {
+		t.Fatalf("Read(%q) found=false, want the stored session", id)
+	}
+	if data != want {
+		t.Fatalf("Read(%q) = %q, want %q", id, truncate(data), truncate(want))
+	}
+}
+
+func expectMissing(t *testing.T, d driver.Driver, id string) {
+	t.Helper()
+	if data, found, err := d.Read(id); found || err != nil {
+		t.Fatalf("Read(%q) = %q found=%v err=%v, want found=false err=nil", id, truncate(data), found, err)
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > 32 {
+		return s[:32] + "..."
+	}
+	return s
+}
+
+func testReadMissing(t *testing.T, d driver.Driver) {
+	expectMissing(t, d, ID)
+}
+
+func testWriteRead(t *testing.T, d driver.Driver) {
+	mustWrite(t, d, ID, "payload")
+	expectData(t, d, ID, "payload")
+}
+
+func testOverwrite(t *testing.T, d driver.Driver) {
+	mustWrite(t, d, ID, "a much longer first payload")
+	mustWrite(t, d, ID, "short")
+	expectData(t, d, ID, "short")
+}
+
+func testPayloads(t *testing.T, d driver.Driver) {
+	payloads := map[string]string{
+		"empty":  "",
+		"binary": "\x00\x01\xff\n\r\t\"'\\",
+		"utf8":   "会话 — セッション",
+		"large":  strings.Repeat("0123456789abcdef", 64<<10),
+	}
+	for name, payload := range payloads {
+		t.Run(name, func(t *testing.T) {
+			mustWrite(t, d, ID, payload)
+			expectData(t, d, ID, payload)
+		})
+	}
+}
+
+func testDistinctIDs(t *testing.T, d driver.Driver) {
+	// IDs differing in a single trailing character must not collide
+	ids := []string{id(1), id(2), id(10)}
+	for _, id := range ids {
+		mustWrite(t, d, id, "data-"+id)
+	}
+	for _, id := range ids {
+		expectData(t, d, id, "data-"+id)
+	}
+}
+
+func testTouch(t *testing.T, d driver.Driver) {
+	if found, err := d.Touch(ID); found || err != nil {
+		t.Fatalf("Touch of missing session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+
+	mustWrite(t, d, ID, "payload")
+	if found, err := d.Touch(ID); !found || err != nil {
+		t.Fatalf("Touch of stored session: found=%v err=%v, want found=true err=nil", found, err)
+	}
+	expectData(t, d, ID, "payload")
+
+	if err := d.Destroy(ID); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if found, err := d.Touch(ID); found || err != nil {
+		t.Fatalf("Touch of destroyed session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+	expectMissing(t, d, ID)
+}
+
+func testDestroy(t *testing.T, d driver.Driver) {
+	if err := d.Destroy(ID); err != nil {
+		t.Fatalf("Destroy of missing session = %v, want nil", err)
+	}
+
+	mustWrite(t, d, ID, "payload")
+	mustWrite(t, d, id(1), "other")
+	for range 2 {
+		if err := d.Destroy(ID); err != nil {
+			t.Fatalf("Destroy = %v, want nil (Destroy must be idempotent)", err)
+		}
+	}
+	expectMissing(t, d, ID)
+	expectData(t, d, id(1), "other")
+
+	// A destroyed ID can be reused
+	mustWrite(t, d, ID, "again")
+	expectData(t, d, ID, "again")
+}
+
+func testGc(t *testing.T, d driver.Driver) {
+	mustWrite(t, d, id(1), "old")
+	mustWrite(t, d, id(2), "touched")
+	time.Sleep(1100 * time.Millisecond)
+
+	if err := d.Gc(3600); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	expectData(t, d, id(1), "old")
+
+	if found, err := d.Touch(id(2)); !found || err != nil {
+		t.Fatalf("Touch: found=%v err=%v", found, err)
+	}
+	mustWrite(t, d, id(3), "fresh")
+	if err := d.Gc(1); err != nil {
+		t.Fatalf("Gc failed: %v", err)
+	}
+	expectMissing(t, d, id(1))
+	expectData(t, d, id(2), "touched")
+	expectData(t, d, id(3), "fresh")
+
+	if err := d.Gc(1); err != nil {
+		t.Fatalf("repeated Gc failed: %v", err)
+	}
+}
+
+func testConcurrent(t *testing.T, d driver.Driver) {
+	// Every write replaces the whole payload; a reader must never observe a
+	// mix of two writes or a partially written one.
+	const size = 32 << 10
+	payload := func(n int) string {
+		return strings.Repeat(string(rune('a'+n%26)), size)
+	}
+	mustWrite(t, d, ID, payload(0))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for w := range 4 {
+		wg.Go(func() {
+			for i := range 25 {
+				if err := d.Write(ID, payload(w*25+i)); err != nil {
+					errs <- fmt.Errorf("Write failed: %w", err)
+					return
+				}
+			}
+		})
+	}
+	for range 4 {
+		wg.Go(func() {
+			for range 50 {
+				data, found, err := d.Read(ID)
+				if err != nil {
+					errs <- fmt.Errorf("Read failed: %w", err)
+					return
+				}
+				if !found {
+					errs <- fmt.Errorf("Read found=false during concurrent writes")
+					return
+				}
+				if len(data) != size || strings.Count(data, data[:1]) != size {
+					errs <- fmt.Errorf("Read observed a torn write: %q", truncate(data))
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testInvalidIDs(t *testing.T, d driver.Driver) {
+	mustWrite(t, d, ID, "payload")
+
+	// A driver may reject IDs it cannot store safely; those then read as
+	// missing. IDs it accepts must round-trip without touching other
+	// sessions, whatever path or key they would map to.
+	ids := []string{
+		"",
+		"../x",
+		"../" + ID,
+		"x/../" + ID,
+		ID + "/.",
+		strings.Repeat("a", 4096),
+	}
+	for _, bad := range ids {
+		t.Run(truncate(bad), func(t *testing.T) {
+			if err := d.Write(bad, "bad"); err != nil {
+				expectMissing(t, d, bad)
+				if found, err := d.Touch(bad); found || err != nil {
+					t.Fatalf("Touch(%q) of a rejected ID: found=%v err=%v, want found=false err=nil", truncate(bad), found, err)
+				}
+			} else {
+				expectData(t, d, bad, "bad")
+			}
+			if err := d.Destroy(bad); err != nil {
+				t.Fatalf("Destroy(%q) = %v, want nil", truncate(bad), err)
+			}
+			expectData(t, d, ID, "payload")
+		})
+	}
+}
+
+func testClose(t *testing.T, d driver.Driver) {
+	mustWrite(t, d, ID, "payload")
+	for range 2 {
+		if err := d.Close(); err != nil {
+			t.Fatalf("Close = %v, want nil (Close must be idempotent)", err)
+		}
+	}
+
+	// A closed driver may fail every call, but must not panic or report a
+	// store failure as a found session.
+	if _, found, err := d.Read(ID); found && err != nil {
+		t.Fatalf("Read after Close: found=%v err=%v", found, err)
+	}
+	if found, err := d.Touch(ID); found && err != nil {
+		t.Fatalf("Touch after Close: found=%v err=%v", found, err)
+	}
+	_ = d.Write(id(1), "payload")
+	_ = d.Destroy(ID)
+	_ = d.Gc(1)
+}
+
+func testList(t *testing.T, d driver.Driver, lister driver.Lister) {
+	want := map[string]string{id(1): "one", id(2): "two", id(3): "three"}
+	for id, data := range want {
+		mustWrite(t, d, id, data)
+	}
+	if err := d.Destroy(id(3)); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	delete(want, id(3))
+
+	got := make(map[string]string)
+	if err := lister.List(func(id string, data string) bool {
+		got[id] = data
+		return true
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+	for id, data := range want {
+		if got[id] != data {
+			t.Fatalf("List = %v, want %v", got, want)
+		}
+	}
+
+	calls := 0
+	if err := lister.List(func(string, string) bool {
+		calls++
+		return false
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("List called fn %d times after it returned false, want 1", calls)
+	}
+}
+
+func testLastActivity(t *testing.T, d driver.Driver, reporter driver.ActivityReporter) {
+	lastActivity := func() time.Time {
+		t.Helper()
+		at, found, err := reporter.LastActivity(ID)
+		if err != nil || !found {
+			t.Fatalf("LastActivity: found=%v err=%v, want the stored session", found, err)
+		}
+		return at
+	}
+
+	if _, found, err := reporter.LastActivity(ID); found || err != nil {
+		t.Fatalf("LastActivity of missing session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	mustWrite(t, d, ID, "payload")
+	written := lastActivity()
+	if written.Before(before) || written.After(time.Now().Add(time.Second)) {
+		t.Fatalf("LastActivity after Write = %v, want about now", written)
+	}
+
+	// Reporting must not refresh the session
+	time.Sleep(20 * time.Millisecond)
+	if again := lastActivity(); !again.Equal(written) {
+		t.Fatalf("LastActivity changed from %v to %v without a write or touch", written, again)
+	}
+
+	if found, err := d.Touch(ID); !found || err != nil {
+		t.Fatalf("Touch: found=%v err=%v", found, err)
+	}
+	if touched := lastActivity(); touched.Before(written) {
+		t.Fatalf("LastActivity after Touch = %v, before the write at %v", touched, written)
+	}
+
+	if err := d.Destroy(ID); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, found, err := reporter.LastActivity(ID); found || err != nil {
+		t.Fatalf("LastActivity of destroyed session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+}
+
+func testReadExpired(t *testing.T, d driver.Driver, clk *clock.Fake) {
+	mustWrite(t, d, ID, "payload")
+	clk.Advance(Lifetime*time.Minute - time.Second)
+	expectData(t, d, ID, "payload")
+
+	clk.Advance(2 * time.Second)
+	expectMissing(t, d, ID)
+
+	// An expired ID can be written again
+	mustWrite(t, d, ID, "again")
+	expectData(t, d, ID, "again")
+}
+
+func testTouchExpired(t *testing.T, d driver.Driver, clk *clock.Fake) {
+	mustWrite(t, d, ID, "payload")
+	clk.Advance(Lifetime*time.Minute + time.Second)
+	for range 2 {
+		if found, err := d.Touch(ID); found || err != nil {
+			t.Fatalf("Touch of expired session: found=%v err=%v, want found=false err=nil", found, err)
+		}
+	}
+	expectMissing(t, d, ID)
+}
+
+func testTouchExtends(t *testing.T, d driver.Driver, clk *clock.Fake) {
+	mustWrite(t, d, ID, "payload")
+	for range 3 {
+		clk.Advance(Lifetime * time.Minute * 3 / 4)
+		if found, err := d.Touch(ID); !found || err != nil {
+			t.Fatalf("Touch within the lifetime: found=%v err=%v, want found=true err=nil", found, err)
+		}
+	}
+	expectData(t, d, ID, "payload")
+}
+
+func testListExpired(t *testing.T, d driver.Driver, clk *clock.Fake, lister driver.Lister) {
+	mustWrite(t, d, id(1), "old")
+	clk.Advance(Lifetime * time.Minute / 2)
+	mustWrite(t, d, id(2), "fresh")
+	clk.Advance(Lifetime*time.Minute/2 + time.Second)
+
+	var got []string
+	if err := lister.List(func(id string, data string) bool {
+		got = append(got, id)
+		return true
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != id(2) {
+		t.Fatalf("List = %v, want only %q", got, id(2))
+	}
+}
+
+func testLastActivityExpired(t *testing.T, d driver.Driver, clk *clock.Fake, reporter driver.ActivityReporter) {
+	mustWrite(t, d, ID, "payload")
+	clk.Advance(Lifetime*time.Minute + time.Second)
+	if _, found, err := reporter.LastActivity(ID); found || err != nil {
+		t.Fatalf("LastActivity of expired session: found=%v err=%v, want found=false err=nil", found, err)
+	}
+}
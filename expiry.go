@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/libtnb/sessions/driver"
+)
+
+// Touch makes the next Save refresh the session's store timestamp with
+// Driver.Touch, even within TouchInterval or after NoSlide, e.g. for an
+// explicit keepalive request. An unchanged session is not rewritten, so
+// Meta.LastActivity, which only advances on writes, stays as it was.
+func (s *Session) Touch() *Session {
+	s.touch = true
+	return s
+}
+
+// NoSlide keeps Save from sliding the session's expiry: an unchanged
+// session is neither touched nor rewritten, and the middleware does not
+// re-send its cookie, e.g. when merely reporting how long it has left.
+// Changes are still written, which does slide it.
+func (s *Session) NoSlide() *Session {
+	s.noSlide = true
+	return s
+}
+
+// IsNoSlide reports whether NoSlide was called.
+func (s *Session) IsNoSlide() bool {
+	return s.noSlide
+}
+
+// ExpiresAt returns when the stored session expires unless it is used
+// again: its last activity plus the manager's Lifetime. Drivers that
+// implement driver.ActivityReporter report the latest write or touch;
+// otherwise Meta.LastActivity is used, which only advances on writes. It
+// returns the zero time for a session that is not (or no longer) stored.
+func (s *Session) ExpiresAt() (time.Time, error) {
+	if !s.persisted {
+		return time.Time{}, nil
+	}
+	at := s.meta.LastActivity
+	if reporter, ok := s.driver.(driver.ActivityReporter); ok {
+		stored, found, err := reporter.LastActivity(s.GetID())
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !found {
+			return time.Time{}, nil
+		}
+		at = stored
+	}
+	return at.Add(s.lifetime()), nil
+}
+
+// lifetime returns the manager's session lifetime.
+func (s *Session) lifetime() time.Duration {
+	if s.manager == nil {
+		return DefaultLifetime * time.Minute
+	}
+	return time.Duration(s.manager.Lifetime) * time.Minute
+}
@@ -0,0 +1,49 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionNoSlideAndExpiresAt(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.Start()
+	if at, err := s.ExpiresAt(); err != nil || !at.IsZero() {
+		t.Fatalf("ExpiresAt of an unsaved session = %v, %v, want the zero time", at, err)
+	}
+	s.Put("k", "v")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// The memory driver reports no activity: Meta.LastActivity is used
+	at, err := s.ExpiresAt()
+	if err != nil {
+		t.Fatalf("ExpiresAt failed: %v", err)
+	}
+	if want := s.Meta().LastActivity.Add(10 * time.Minute); !at.Equal(want) {
+		t.Fatalf("ExpiresAt = %v, want %v", at, want)
+	}
+
+	s.NoSlide()
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if d.touches != 0 || d.writes != 1 {
+		t.Fatalf("NoSlide Save: touches=%d writes=%d, want neither", d.touches, d.writes-1)
+	}
+	s.Touch()
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if d.touches != 1 || d.writes != 1 {
+		t.Fatalf("Touch Save: touches=%d writes=%d, want one touch and no write", d.touches, d.writes-1)
+	}
+}
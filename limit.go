@@ -0,0 +1,196 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/libtnb/sessions/driver"
+)
+
+// ErrSessionLimitReached is returned by SetUserID when the user already
+// holds ManagerOptions.MaxSessionsPerUser sessions and the policy rejects
+// the new one.
+var ErrSessionLimitReached = errors.New("session limit reached")
+
+// ErrSessionIndexRequired is returned by NewManager when MaxSessionsPerUser
+// is set without a SessionIndex to keep the user indexes in.
+var ErrSessionIndexRequired = errors.New("session limit requires ManagerOptions.SessionIndex")
+
+// SessionLimitPolicy selects what happens when an authenticated user would
+// exceed ManagerOptions.MaxSessionsPerUser.
+type SessionLimitPolicy int
+
+const (
+	// SessionLimitEvictOldest destroys the user's least recently active
+	// sessions to make room for the new one.
+	SessionLimitEvictOldest SessionLimitPolicy = iota
+	// SessionLimitRejectNew keeps the existing sessions and fails the new
+	// one with ErrSessionLimitReached.
+	SessionLimitRejectNew
+	// SessionLimitCallback leaves the decision to
+	// ManagerOptions.OnSessionLimit.
+	SessionLimitCallback
+)
+
+// SessionInfo identifies a stored session and its metadata.
+type SessionInfo struct {
+	ID   string
+	Meta Meta
+}
+
+// userIndexPrefix namespaces the locks of user indexes.
+const userIndexPrefix = "sessions/user-index:"
+
+// userIndexContext separates the user index key derived from
+// ManagerOptions.Key from the key's other uses.
+const userIndexContext = "sessions/user-index"
+
+// enforceSessionLimit makes room for s to be authenticated as userID. The
+// user's sessions are found through their user index, so the cap holds
+// across every replica sharing the store at a cost proportional to the
+// user's sessions; two logins racing on different replicas may still
+// briefly exceed it.
+func (m *Manager) enforceSessionLimit(s *Session, userID string) error {
+	if m.maxPerUser <= 0 {
+		return nil
+	}
+
+	active, err := m.userSessions(s, userID)
+	if err != nil {
+		return err
+	}
+	if len(active) < m.maxPerUser {
+		return nil
+	}
+
+	switch m.sessionLimit {
+	case SessionLimitRejectNew:
+		return ErrSessionLimitReached
+	case SessionLimitCallback:
+		if m.onSessionLimit == nil {
+			return ErrSessionLimitReached
+		}
+		return m.onSessionLimit(s, active)
+	default:
+		slices.SortFunc(active, func(a, b SessionInfo) int {
+			return a.Meta.LastActivity.Compare(b.Meta.LastActivity)
+		})
+		var errs []error
+		for _, info := range active[:len(active)-m.maxPerUser+1] {
+			errs = append(errs, m.destroySession(s.driver, info.ID))
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// userSessions returns the live sessions of userID other than s, pruning
+// the user index of sessions that expired, were destroyed or changed hands.
+// Meta.LastActivity is taken from the driver when it implements
+// driver.ActivityReporter, as it then also reflects touches.
+func (m *Manager) userSessions(s *Session, userID string) ([]SessionInfo, error) {
+	key := m.userIndexKey(userID)
+	m.LockSession(userIndexPrefix + key)
+	defer m.UnlockSession(userIndexPrefix + key)
+
+	ids, err := readUserIndex(m.userIndex, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, _ := s.driver.(driver.ActivityReporter)
+	var active []SessionInfo
+	live := make([]string, 0, len(ids))
+	for _, id := range ids {
+		data, found, err := s.driver.Read(id)
+		if err != nil {
+			return nil, err
+		}
+		var rec *record
+		if found {
+			rec = decodeRecord(m.Codec, s.GetName(), data)
+		}
+		if rec == nil || rec.Meta.UserID != userID {
+			continue
+		}
+		live = append(live, id)
+		if id == s.GetID() {
+			continue
+		}
+		if reporter != nil {
+			if at, found, err := reporter.LastActivity(id); err == nil && found {
+				rec.Meta.LastActivity = at
+			}
+		}
+		active = append(active, SessionInfo{ID: id, Meta: rec.Meta})
+	}
+
+	if len(live) != len(ids) {
+		if err = writeUserIndex(m.userIndex, key, live); err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+// trackSession adds a session Save just stored or touched to its user
+// index when it was just authenticated or moved to a new ID; other saves
+// leave the index alone. Failures are logged: the session is then missing
+// from the index until its next save.
+func (m *Manager) trackSession(s *Session) {
+	if m.maxPerUser <= 0 || s.meta.UserID == "" || !s.indexPending {
+		return
+	}
+	key := m.userIndexKey(s.meta.UserID)
+
+	m.LockSession(userIndexPrefix + key)
+	defer m.UnlockSession(userIndexPrefix + key)
+	ids, err := readUserIndex(m.userIndex, key)
+	if err == nil && !slices.Contains(ids, s.GetID()) {
+		err = writeUserIndex(m.userIndex, key, append(ids, s.GetID()))
+	}
+	if err != nil {
+		m.logger.Error("session user index update failed", "error", err)
+		return
+	}
+	s.indexPending = false
+}
+
+// newUserIndexKey derives the key user index keys are computed with from
+// the manager key.
+func newUserIndexKey(key string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userIndexContext))
+	return mac.Sum(nil)
+}
+
+// userIndexKey returns the store key of the user index of userID: a keyed
+// hash, so the keys reveal nothing about the users to whoever can list the
+// store, as 32 hexadecimal characters, shaped like a sessionid.Default ID
+// for drivers that validate keys.
+func (m *Manager) userIndexKey(userID string) string {
+	mac := hmac.New(sha256.New, m.indexKey)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// readUserIndex returns the session IDs in the user index under key. The
+// index is a plain newline-separated list; session IDs never contain
+// whitespace.
+func readUserIndex(index driver.Driver, key string) ([]string, error) {
+	data, found, err := index.Read(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	return strings.Fields(data), nil
+}
+
+func writeUserIndex(index driver.Driver, key string, ids []string) error {
+	if len(ids) == 0 {
+		return index.Destroy(key)
+	}
+	return index.Write(key, strings.Join(ids, "\n"))
+}
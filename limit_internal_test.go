@@ -0,0 +1,300 @@
+package sessions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/sessionid"
+)
+
+// loginSession starts a fresh session, marks it as the user's and saves it.
+func loginSession(t *testing.T, manager *Manager, userID string) (string, error) {
+	t.Helper()
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.Start()
+	if err = s.SetUserID(userID); err != nil {
+		return "", err
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	return s.GetID(), nil
+}
+
+// limitManager returns a manager capping each user at maxPerUser sessions,
+// with its user indexes in index.
+func limitManager(t *testing.T, d *memoryDriver, index *memoryDriver, maxPerUser int) *Manager {
+	t.Helper()
+
+	manager := testManagerWithDriver(t, d)
+	manager.maxPerUser = maxPerUser
+	manager.userIndex = index
+	manager.indexKey = newUserIndexKey("12345678901234567890123456789012")
+	return manager
+}
+
+func TestSessionLimitEvictsOldest(t *testing.T) {
+	d := newMemoryDriver()
+	manager := limitManager(t, d, newMemoryDriver(), 2)
+
+	first, _ := loginSession(t, manager, "alice")
+	second, _ := loginSession(t, manager, "alice")
+	other, _ := loginSession(t, manager, "bob")
+
+	// A request on the first session is still in flight when it gets evicted
+	inflight, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	inflight.SetID(first)
+	inflight.Start()
+	inflight.Put("late", "write")
+
+	third, err := loginSession(t, manager, "alice")
+	if err != nil {
+		t.Fatalf("third login failed: %v", err)
+	}
+
+	d.mu.Lock()
+	_, firstExists := d.data[first]
+	_, secondExists := d.data[second]
+	_, thirdExists := d.data[third]
+	_, otherExists := d.data[other]
+	d.mu.Unlock()
+	if firstExists {
+		t.Fatal("oldest session was not evicted")
+	}
+	if !secondExists || !thirdExists || !otherExists {
+		t.Fatal("only the oldest session of the user may be evicted")
+	}
+
+	if err = inflight.Save(); !errors.Is(err, ErrSessionDestroyed) {
+		t.Fatalf("Save on evicted session = %v, want ErrSessionDestroyed", err)
+	}
+	manager.ReleaseSession(inflight)
+}
+
+func TestSessionLimitRejectsNew(t *testing.T) {
+	manager := limitManager(t, newMemoryDriver(), newMemoryDriver(), 1)
+	manager.sessionLimit = SessionLimitRejectNew
+
+	if _, err := loginSession(t, manager, "alice"); err != nil {
+		t.Fatalf("first login failed: %v", err)
+	}
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.Start()
+	if err = s.SetUserID("alice"); !errors.Is(err, ErrSessionLimitReached) {
+		t.Fatalf("SetUserID = %v, want ErrSessionLimitReached", err)
+	}
+	if s.Meta().UserID != "" {
+		t.Fatal("rejected session must stay anonymous")
+	}
+}
+
+func TestSessionLimitCallbackDecides(t *testing.T) {
+	manager := limitManager(t, newMemoryDriver(), newMemoryDriver(), 1)
+	manager.sessionLimit = SessionLimitCallback
+
+	first, _ := loginSession(t, manager, "alice")
+
+	var got []SessionInfo
+	manager.onSessionLimit = func(s *Session, active []SessionInfo) error {
+		got = active
+		return nil
+	}
+	if _, err := loginSession(t, manager, "alice"); err != nil {
+		t.Fatalf("login allowed by callback failed: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != first || got[0].Meta.UserID != "alice" {
+		t.Fatalf("callback received %+v, want the first session", got)
+	}
+}
+
+// activityDriver reports the order of writes and touches as activity times.
+type activityDriver struct {
+	*memoryDriver
+	seq int64
+	at  map[string]time.Time
+}
+
+func newActivityDriver() *activityDriver {
+	return &activityDriver{memoryDriver: newMemoryDriver(), at: make(map[string]time.Time)}
+}
+
+func (d *activityDriver) record(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	d.at[id] = time.Unix(d.seq, 0)
+}
+
+func (d *activityDriver) Write(id string, data string) error {
+	if err := d.memoryDriver.Write(id, data); err != nil {
+		return err
+	}
+	d.record(id)
+	return nil
+}
+
+func (d *activityDriver) Touch(id string) (bool, error) {
+	found, err := d.memoryDriver.Touch(id)
+	if found {
+		d.record(id)
+	}
+	return found, err
+}
+
+func (d *activityDriver) LastActivity(id string) (time.Time, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.at[id]
+	return at, ok, nil
+}
+
+func TestSessionLimitUsesUserIndex(t *testing.T) {
+	d := newMemoryDriver()
+	index := newMemoryDriver()
+	manager := limitManager(t, d, index, 1)
+
+	login := func() string {
+		s, err := manager.BuildSession(CookieName, "mock")
+		if err != nil {
+			t.Fatalf("BuildSession failed: %v", err)
+		}
+		defer manager.ReleaseSession(s)
+		s.Start()
+		if err = s.Login("alice"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		if err = s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return s.GetID()
+	}
+
+	// Login moves the session to a new ID; the index must follow it
+	first := login()
+	second := login()
+	if d.lists != 0 {
+		t.Fatalf("enforcing the limit listed the store %d times, want 0", d.lists)
+	}
+	d.mu.Lock()
+	_, firstExists := d.data[first]
+	_, secondExists := d.data[second]
+	sessions := len(d.data)
+	d.mu.Unlock()
+	if firstExists || !secondExists {
+		t.Fatalf("first=%v second=%v, want only the second session kept", firstExists, secondExists)
+	}
+	if sessions != 1 {
+		t.Fatalf("session store holds %d entries, want the session only", sessions)
+	}
+
+	// Later saves of the session leave the index alone
+	writes := index.writes
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.SetID(second)
+	s.Start()
+	s.Put("cart", 1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	manager.ReleaseSession(s)
+	if index.writes != writes || index.touches != 0 {
+		t.Fatalf("saves of an indexed session: index writes=%d touches=%d, want none", index.writes-writes, index.touches)
+	}
+
+	// Destroyed sessions are pruned from the index
+	if err := manager.destroySession(d, second); err != nil {
+		t.Fatalf("destroySession failed: %v", err)
+	}
+	s, _ = manager.BuildSession(CookieName, "mock")
+	defer manager.ReleaseSession(s)
+	s.Start()
+	active, err := manager.userSessions(s, "alice")
+	if err != nil || len(active) != 0 {
+		t.Fatalf("userSessions = %v, %v, want none", active, err)
+	}
+	if ids, _ := readUserIndex(index, manager.userIndexKey("alice")); len(ids) != 0 {
+		t.Fatalf("user index still lists %v", ids)
+	}
+}
+
+func TestUserIndexKeyIsKeyed(t *testing.T) {
+	manager := limitManager(t, newMemoryDriver(), newMemoryDriver(), 1)
+	other := limitManager(t, newMemoryDriver(), newMemoryDriver(), 1)
+	other.indexKey = newUserIndexKey("abcdefghijklmnopqrstuvwxyz123456")
+
+	key := manager.userIndexKey("alice")
+	if !sessionid.Default.Valid(key) {
+		t.Fatalf("user index key %q is not shaped like a session ID", key)
+	}
+	if key == other.userIndexKey("alice") {
+		t.Fatal("user index keys do not depend on the manager key")
+	}
+	if key == manager.userIndexKey("bob") {
+		t.Fatal("different users share a user index key")
+	}
+}
+
+func TestSessionLimitEvictsLeastRecentlyActive(t *testing.T) {
+	d := newActivityDriver()
+	manager, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		MaxSessionsPerUser:   2,
+		SessionIndex:         newMemoryDriver(),
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	manager.drivers["mock"] = d
+
+	first, _ := loginSession(t, manager, "alice")
+	second, _ := loginSession(t, manager, "alice")
+
+	// The first session stays in use without changes: only touched
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.SetID(first)
+	s.Start()
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	manager.ReleaseSession(s)
+
+	if _, err = loginSession(t, manager, "alice"); err != nil {
+		t.Fatalf("third login failed: %v", err)
+	}
+	d.mu.Lock()
+	_, firstExists := d.data[first]
+	_, secondExists := d.data[second]
+	d.mu.Unlock()
+	if !firstExists || secondExists {
+		t.Fatalf("first=%v second=%v, want the idle second session evicted", firstExists, secondExists)
+	}
+}
+
+func TestNewManagerRequiresSessionIndex(t *testing.T) {
+	_, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		MaxSessionsPerUser:   2,
+	})
+	if !errors.Is(err, ErrSessionIndexRequired) {
+		t.Fatalf("NewManager = %v, want ErrSessionIndexRequired", err)
+	}
+}
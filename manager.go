@@ -1,6 +1,8 @@
 package sessions
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -10,7 +12,9 @@ import (
 
 	"github.com/libtnb/securecookie"
 
+	"github.com/libtnb/sessions/clock"
 	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/sessionid"
 )
 
 var (
@@ -24,6 +28,7 @@ var (
 	ErrDriverNotSet       = errors.New("driver is not set")
 	ErrDriverNotSupported = errors.New("driver not supported")
 	ErrDriverExists       = errors.New("driver already exists")
+	ErrDriverNotListable  = errors.New("driver cannot list sessions")
 )
 
 const (
@@ -41,24 +46,98 @@ type ManagerOptions struct {
 	// GcInterval is the session garbage collection interval in minutes.
 	// Defaults to DefaultGcInterval.
 	GcInterval int
+	// TouchInterval throttles the store refresh of unchanged sessions, in
+	// minutes: an unchanged session is refreshed only once its last recorded
+	// activity is older than this. Keep it well below Lifetime, since the
+	// stored timestamp may lag by up to this much. 0 refreshes on every save.
+	TouchInterval int
 	// DisableDefaultDriver disables the default file driver if set to true.
 	DisableDefaultDriver bool
+	// MaxSessionsPerUser caps how many sessions one authenticated user may
+	// hold, enforced when SetUserID marks a session as authenticated. Each
+	// user's session IDs are kept in a user index in SessionIndex, which is
+	// then required. 0 means unlimited.
+	MaxSessionsPerUser int
+	// SessionIndex stores the user indexes of MaxSessionsPerUser, apart from
+	// the sessions, e.g. driver.NewFile(dir, lifetime). Its keys are 32
+	// hexadecimal characters keyed with Key. An index is written when one
+	// of the user's sessions is authenticated or moves to a new ID and is
+	// garbage collected after the longer of Lifetime and the remember-me
+	// lifetime; give the driver at least that lifetime. A session kept in
+	// use for longer without the user logging in again no longer counts
+	// towards the cap.
+	SessionIndex driver.Driver
+	// SessionLimit selects what happens when a user reaches
+	// MaxSessionsPerUser. Defaults to SessionLimitEvictOldest.
+	SessionLimit SessionLimitPolicy
+	// OnSessionLimit decides over a session that would exceed the cap when
+	// SessionLimit is SessionLimitCallback; returning an error rejects it.
+	OnSessionLimit func(s *Session, active []SessionInfo) error
+	// Remember, when set, enables remember-me tokens that revive an
+	// authenticated session after it expired.
+	Remember *RememberOptions
+	// Clock supplies the current time for session timestamps, activity and
+	// expiry decisions and drives the garbage collection timers. Defaults to
+	// clock.System; tests inject a fake clock. Drivers take their own clock
+	// option, which should be the same.
+	Clock clock.Clock
+	// IDGenerator creates and validates session IDs, e.g. longer IDs,
+	// IDs with a routing prefix (sessionid.Prefixed) or time-ordered ones
+	// (sessionid.UUIDv7). Defaults to sessionid.Default. Drivers that
+	// validate IDs, like the file driver, must be given the same generator.
+	IDGenerator sessionid.Generator
+	// SignIDs makes the cookie carry the session ID with an HMAC signature,
+	// so the middleware rejects forged or random IDs without a store lookup.
+	// The signing key is derived from Key unless IDSigningKeys is set.
+	SignIDs bool
+	// IDSigningKeys replaces the derived ID signing key, newest first: the
+	// first key signs, every key verifies. Rotate by prepending a new key
+	// and dropping the oldest once a Lifetime has passed; cookies are
+	// re-signed with the newest key on every response. Each key must be at
+	// least 32 bytes; NewManager fails with ErrWeakIDSigningKey otherwise.
+	IDSigningKeys []string
+	// Persist selects when a new session is first stored. Defaults to
+	// PersistAlways; PersistWhenNeeded keeps empty sessions of anonymous
+	// visitors out of the store.
+	Persist PersistPolicy
+	// Debug panics with ErrSessionReadOnly on writes to a read-only session
+	// instead of dropping them and failing Save, to catch them early.
+	Debug bool
 	// Logger receives background errors (garbage collection, middleware
 	// saves). Defaults to slog.Default().
 	Logger *slog.Logger
 }
 
 type Manager struct {
-	Codec      securecookie.Codec
-	Lifetime   int
-	GcInterval int
+	Codec         securecookie.Codec
+	Lifetime      int
+	GcInterval    int
+	TouchInterval int
+	// RememberLifetime is the remember-me token lifetime in minutes, or 0
+	// when remember-me is not enabled.
+	RememberLifetime int
 
 	logger         *slog.Logger
+	clock          clock.Clock
+	ids            sessionid.Generator
+	idKeys         [][]byte
+	indexKey       []byte // keys the user index keys
+	maxPerUser     int
+	userIndex      driver.Driver
+	sessionLimit   SessionLimitPolicy
+	onSessionLimit func(s *Session, active []SessionInfo) error
+	persist        PersistPolicy
+	debug          bool
+	remember       *rememberStore
 	driversMu      sync.RWMutex
 	drivers        map[string]driver.Driver
 	sessionPool    sync.Pool
 	sessionLocksMu sync.Mutex
 	sessionLocks   map[string]*sessionLock
+	sessionSems    map[string]*sessionSemaphore
+	touchesMu      sync.Mutex
+	touches        map[string]*list.Element // last Touch of read-only sessions
+	touchOrder     list.List                // of *touchRecord, least recent first
 	gcDone         chan struct{}
 	closeOnce      sync.Once
 }
@@ -68,6 +147,11 @@ type sessionLock struct {
 	refs int
 }
 
+type sessionSemaphore struct {
+	slots chan struct{}
+	refs  int // holders and waiters
+}
+
 // NewManager creates a new session manager.
 func NewManager(option *ManagerOptions) (*Manager, error) {
 	lifetime := option.Lifetime
@@ -78,10 +162,19 @@ func NewManager(option *ManagerOptions) (*Manager, error) {
 	if gcInterval <= 0 {
 		gcInterval = DefaultGcInterval
 	}
+	touchInterval := max(option.TouchInterval, 0)
 	logger := option.Logger
 	if logger == nil {
 		logger = slog.Default()
 	}
+	clk := option.Clock
+	if clk == nil {
+		clk = clock.System
+	}
+	ids := option.IDGenerator
+	if ids == nil {
+		ids = sessionid.Default
+	}
 
 	codec, err := securecookie.New([]byte(option.Key), &securecookie.Options{
 		MaxAge:     int64(lifetime) * 60,
@@ -91,13 +184,24 @@ func NewManager(option *ManagerOptions) (*Manager, error) {
 		return nil, err
 	}
 	manager := &Manager{
-		Codec:        codec,
-		Lifetime:     lifetime,
-		GcInterval:   gcInterval,
-		logger:       logger,
-		drivers:      make(map[string]driver.Driver),
-		sessionLocks: make(map[string]*sessionLock),
-		gcDone:       make(chan struct{}),
+		Codec:          codec,
+		Lifetime:       lifetime,
+		GcInterval:     gcInterval,
+		TouchInterval:  touchInterval,
+		logger:         logger,
+		clock:          clk,
+		ids:            ids,
+		maxPerUser:     max(option.MaxSessionsPerUser, 0),
+		userIndex:      option.SessionIndex,
+		sessionLimit:   option.SessionLimit,
+		onSessionLimit: option.OnSessionLimit,
+		persist:        option.Persist,
+		debug:          option.Debug,
+		drivers:        make(map[string]driver.Driver),
+		sessionLocks:   make(map[string]*sessionLock),
+		sessionSems:    make(map[string]*sessionSemaphore),
+		touches:        make(map[string]*list.Element),
+		gcDone:         make(chan struct{}),
 		sessionPool: sync.Pool{New: func() any {
 			return &Session{
 				attributes: make(map[string]any),
@@ -108,6 +212,39 @@ func NewManager(option *ManagerOptions) (*Manager, error) {
 		},
 	}
 
+	if option.SignIDs {
+		if manager.idKeys, err = newIDSigningKeys(option.Key, option.IDSigningKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if manager.maxPerUser > 0 {
+		if option.SessionIndex == nil {
+			return nil, ErrSessionIndexRequired
+		}
+		manager.indexKey = newUserIndexKey(option.Key)
+	}
+
+	if option.Remember != nil {
+		manager.RememberLifetime = option.Remember.Lifetime
+		if manager.RememberLifetime <= 0 {
+			manager.RememberLifetime = DefaultRememberLifetime
+		}
+		manager.remember, err = newRememberStore([]byte(option.Key), option.Remember, manager.RememberLifetime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Start the garbage collection timers only once nothing can fail, so an
+	// error above leaks no goroutine
+	if manager.maxPerUser > 0 {
+		manager.startGcTimer(option.SessionIndex, max(lifetime, manager.RememberLifetime))
+	}
+	if manager.remember != nil {
+		manager.startGcTimer(option.Remember.Driver, manager.RememberLifetime)
+	}
+
 	if !option.DisableDefaultDriver {
 		return manager, manager.createDefaultDriver()
 	}
@@ -154,6 +291,49 @@ func (m *Manager) HasSession(r *http.Request, key ...any) bool {
 	return ok
 }
 
+// Sessions calls fn with the ID and metadata of every stored session of the
+// given name until fn returns false. The driver must implement driver.Lister;
+// sessions that cannot be decoded (corrupt data, rotated key) are skipped.
+func (m *Manager) Sessions(name string, fn func(id string, meta Meta) bool, driverName ...string) error {
+	handler, err := m.driver(driverName...)
+	if err != nil {
+		return err
+	}
+	return m.listSessions(handler, name, fn)
+}
+
+// DestroySession destroys the stored session with the given ID, e.g. to log
+// out a device from an admin tool. An in-flight request still holding the
+// session fails to save it with ErrSessionDestroyed.
+func (m *Manager) DestroySession(id string, driverName ...string) error {
+	handler, err := m.driver(driverName...)
+	if err != nil {
+		return err
+	}
+	return m.destroySession(handler, id)
+}
+
+func (m *Manager) destroySession(handler driver.Driver, id string) error {
+	m.LockSession(id)
+	defer m.UnlockSession(id)
+	return handler.Destroy(id)
+}
+
+func (m *Manager) listSessions(handler driver.Driver, name string, fn func(id string, meta Meta) bool) error {
+	lister, ok := handler.(driver.Lister)
+	if !ok {
+		return ErrDriverNotListable
+	}
+
+	return lister.List(func(id string, data string) bool {
+		rec := decodeRecord(m.Codec, name, data)
+		if rec == nil {
+			return true
+		}
+		return fn(id, rec.Meta)
+	})
+}
+
 // Extend registers a custom driver under the given name and starts its
 // garbage collection timer. It is safe for concurrent use.
 func (m *Manager) Extend(name string, handler driver.Driver) error {
@@ -165,7 +345,7 @@ func (m *Manager) Extend(name string, handler driver.Driver) error {
 	m.drivers[name] = handler
 	m.driversMu.Unlock()
 
-	m.startGcTimer(handler)
+	m.startGcTimer(handler, m.Lifetime)
 	return nil
 }
 
@@ -183,6 +363,16 @@ func (m *Manager) Close() error {
 				errs = append(errs, fmt.Errorf("close driver [%s]: %w", name, err))
 			}
 		}
+		if m.userIndex != nil {
+			if err := m.userIndex.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close session index driver: %w", err))
+			}
+		}
+		if m.remember != nil {
+			if err := m.remember.driver.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close remember driver: %w", err))
+			}
+		}
 	})
 	return errors.Join(errs...)
 }
@@ -192,6 +382,17 @@ func (m *Manager) Logger() *slog.Logger {
 	return m.logger
 }
 
+// Clock returns the clock the manager was configured with.
+func (m *Manager) Clock() clock.Clock {
+	return m.clock
+}
+
+// IDGenerator returns the session ID generator the manager was configured
+// with.
+func (m *Manager) IDGenerator() sessionid.Generator {
+	return m.ids
+}
+
 // AcquireSession takes a session from the pool.
 func (m *Manager) AcquireSession() *Session {
 	session := m.sessionPool.Get().(*Session)
@@ -243,6 +444,65 @@ func (m *Manager) UnlockSession(id string) {
 	}
 }
 
+// AcquireSessionSemaphore takes one of n slots of the semaphore for the
+// given ID, waiting while all n are held. It is LockSession for up to n
+// holders at once, e.g. to cap the concurrent requests of one session; every
+// caller must pass the same n for an ID. A free slot is taken even if ctx is
+// already done, so a done ctx makes it a try-acquire; otherwise it returns
+// ctx.Err() when ctx is done first. Release the slot with
+// ReleaseSessionSemaphore.
+func (m *Manager) AcquireSessionSemaphore(ctx context.Context, id string, n int) error {
+	m.sessionLocksMu.Lock()
+	sem, ok := m.sessionSems[id]
+	if !ok {
+		sem = &sessionSemaphore{slots: make(chan struct{}, max(n, 1))}
+		m.sessionSems[id] = sem
+	}
+	sem.refs++
+	m.sessionLocksMu.Unlock()
+
+	select {
+	case sem.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case sem.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		m.dropSessionSemaphore(id, sem)
+		return ctx.Err()
+	}
+}
+
+// ReleaseSessionSemaphore releases a slot taken with
+// AcquireSessionSemaphore.
+func (m *Manager) ReleaseSessionSemaphore(id string) {
+	m.sessionLocksMu.Lock()
+	sem, ok := m.sessionSems[id]
+	m.sessionLocksMu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case <-sem.slots:
+		m.dropSessionSemaphore(id, sem)
+	default:
+		// Not held
+	}
+}
+
+// dropSessionSemaphore forgets one holder or waiter of sem, removing it once
+// nobody uses it.
+func (m *Manager) dropSessionSemaphore(id string, sem *sessionSemaphore) {
+	m.sessionLocksMu.Lock()
+	defer m.sessionLocksMu.Unlock()
+	sem.refs--
+	if sem.refs == 0 && m.sessionSems[id] == sem {
+		delete(m.sessionSems, id)
+	}
+}
+
 func (m *Manager) driver(name ...string) (driver.Driver, error) {
 	driverName := "default"
 	if len(name) > 0 {
@@ -264,8 +524,10 @@ func (m *Manager) driver(name ...string) (driver.Driver, error) {
 	return handler, nil
 }
 
-func (m *Manager) startGcTimer(driver driver.Driver) {
-	ticker := time.NewTicker(time.Duration(m.GcInterval) * time.Minute)
+// startGcTimer periodically removes the driver's entries older than
+// lifetime minutes until the manager is closed.
+func (m *Manager) startGcTimer(driver driver.Driver, lifetime int) {
+	ticker := m.clock.NewTicker(time.Duration(m.GcInterval) * time.Minute)
 
 	go func() {
 		defer ticker.Stop()
@@ -273,8 +535,8 @@ func (m *Manager) startGcTimer(driver driver.Driver) {
 			select {
 			case <-m.gcDone:
 				return
-			case <-ticker.C:
-				if err := driver.Gc(m.Lifetime * 60); err != nil {
+			case <-ticker.C():
+				if err := driver.Gc(lifetime * 60); err != nil {
 					m.logger.Error("session gc failed", "error", err)
 				}
 			}
@@ -283,5 +545,9 @@ func (m *Manager) startGcTimer(driver driver.Driver) {
 }
 
 func (m *Manager) createDefaultDriver() error {
-	return m.Extend("default", driver.NewFile("", m.Lifetime))
+	return m.Extend("default", driver.NewFileWithOptions(driver.FileOptions{
+		Lifetime:    m.Lifetime,
+		Clock:       m.clock,
+		IDGenerator: m.ids,
+	}))
 }
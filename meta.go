@@ -0,0 +1,94 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/libtnb/securecookie"
+)
+
+// Meta is the bookkeeping kept for a session next to its attributes. It is
+// stored apart from the attribute map, so it can never collide with user
+// keys or internals such as the flash bookkeeping.
+type Meta struct {
+	// CreatedAt is when the session was first started.
+	CreatedAt time.Time
+	// LastActivity is when the session was last written to the store. With
+	// a TouchInterval it may lag the latest request by up to that interval.
+	LastActivity time.Time
+	// IP is the client address recorded by the latest request.
+	IP string
+	// UserAgent is the client user agent recorded by the latest request.
+	UserAgent string
+	// Fingerprint identifies the client the session is bound to, if any.
+	Fingerprint string
+	// UserID is the authenticated user the session belongs to, if any.
+	UserID string
+	// AuthenticatedAt is when the user logged in with Login.
+	AuthenticatedAt time.Time
+	// PasswordConfirmedAt is when the user last proved their identity, by
+	// logging in or with ConfirmPassword.
+	PasswordConfirmedAt time.Time
+	// CookieExpiresAt is when the session cookie last sent to the client
+	// expires. It is only tracked when the middleware throttles cookie
+	// refreshes.
+	CookieExpiresAt time.Time
+}
+
+// record is the persisted form of a session.
+type record struct {
+	Attributes map[string]any
+	Meta       Meta
+}
+
+// mergeMeta applies the fields changed during this request (ours differs
+// from base, the state loaded at Start) on top of the latest stored meta, so
+// concurrent requests only overwrite what they actually changed.
+func mergeMeta(base, ours, theirs Meta) Meta {
+	merged := theirs
+	if !ours.CreatedAt.Equal(base.CreatedAt) {
+		merged.CreatedAt = ours.CreatedAt
+	}
+	if ours.IP != base.IP {
+		merged.IP = ours.IP
+	}
+	if ours.UserAgent != base.UserAgent {
+		merged.UserAgent = ours.UserAgent
+	}
+	if ours.Fingerprint != base.Fingerprint {
+		merged.Fingerprint = ours.Fingerprint
+	}
+	if ours.UserID != base.UserID {
+		merged.UserID = ours.UserID
+	}
+	if !ours.AuthenticatedAt.Equal(base.AuthenticatedAt) {
+		merged.AuthenticatedAt = ours.AuthenticatedAt
+	}
+	if !ours.PasswordConfirmedAt.Equal(base.PasswordConfirmedAt) {
+		merged.PasswordConfirmedAt = ours.PasswordConfirmedAt
+	}
+	if !ours.CookieExpiresAt.Equal(base.CookieExpiresAt) {
+		merged.CookieExpiresAt = ours.CookieExpiresAt
+	}
+	return merged
+}
+
+// decodeRecord decodes a stored session, or returns nil when the payload
+// cannot be decoded. Sessions written before metadata existed hold a bare
+// attribute map; they are upgraded on the fly, with the codec timestamp
+// standing in for the last activity.
+func decodeRecord(codec securecookie.Codec, name, value string) *record {
+	var rec record
+	if _, err := codec.Decode(name, value, &rec); err != nil {
+		var data map[string]any
+		ts, err := codec.Decode(name, value, &data)
+		if err != nil {
+			return nil
+		}
+		rec = record{Attributes: data, Meta: Meta{LastActivity: time.Unix(ts, 0)}}
+	}
+	if rec.Attributes == nil {
+		// gob omits empty maps
+		rec.Attributes = make(map[string]any)
+	}
+	return &rec
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// AuthConfig customizes the RequireAuth and RequireRecentAuth guards.
+type AuthConfig struct {
+	// RedirectTo, when set, redirects rejected requests to this URL (the
+	// login or password confirmation page) instead of responding 401
+	// Unauthorized. For GET requests the original URL is remembered, so the
+	// page can send the user back with Session.IntendedURL; URLs that a
+	// browser would resolve to another host are never remembered.
+	RedirectTo string
+	// Unauthorized, when set, handles rejected requests instead of the
+	// redirect or the plain 401 response.
+	Unauthorized http.Handler
+}
+
+// RequireAuth is a guard that only lets requests through whose session was
+// authenticated with Session.Login. It must run inside StartSession.
+func RequireAuth(manager *sessions.Manager, cfg AuthConfig) func(next http.Handler) http.Handler {
+	return guard(manager, cfg, func(s *sessions.Session) bool {
+		return s.UserID() != ""
+	})
+}
+
+// RequireRecentAuth is a guard for sensitive actions (changing the password,
+// deleting the account): it only lets requests through whose user logged in
+// or called Session.ConfirmPassword within maxAge. Point cfg.RedirectTo at
+// the password confirmation page. It must run inside StartSession.
+func RequireRecentAuth(manager *sessions.Manager, maxAge time.Duration, cfg AuthConfig) func(next http.Handler) http.Handler {
+	return guard(manager, cfg, func(s *sessions.Session) bool {
+		return s.RecentlyConfirmed(maxAge)
+	})
+}
+
+// guard lets requests through whose session satisfies allow and rejects the
+// others as configured by cfg.
+func guard(manager *sessions.Manager, cfg AuthConfig, allow func(*sessions.Session) bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, err := manager.GetSession(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if allow(s) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case cfg.Unauthorized != nil:
+				cfg.Unauthorized.ServeHTTP(w, r)
+			case cfg.RedirectTo != "":
+				if uri := r.URL.RequestURI(); r.Method == http.MethodGet && localPath(uri) {
+					s.SetIntendedURL(uri)
+				}
+				http.Redirect(w, r, cfg.RedirectTo, http.StatusFound)
+			default:
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// localPath reports whether uri is a path on this site. Browsers read "//"
+// and "/\" as the start of another host, so an intended URL like
+// "//evil.example" would turn the post-login redirect into an open redirect.
+func localPath(uri string) bool {
+	return strings.HasPrefix(uri, "/") && !strings.HasPrefix(uri, "//") && !strings.HasPrefix(uri, "/\\")
+}
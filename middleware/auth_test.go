@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestRequireAuth(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret"))
+	})
+	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		if err := s.Login("alice"); err != nil {
+			t.Errorf("Login failed: %v", err)
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		cfg        AuthConfig
+		wantStatus int
+	}{
+		{"unauthorized", AuthConfig{}, http.StatusUnauthorized},
+		{"redirect", AuthConfig{RedirectTo: "/login"}, http.StatusFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("/login", login)
+			mux.Handle("/account", RequireAuth(manager, tt.cfg)(protected))
+			handler := StartSession(manager, "mock")(mux)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/account", nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("anonymous status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.cfg.RedirectTo != "" && rr.Header().Get("Location") != tt.cfg.RedirectTo {
+				t.Fatalf("Location = %q, want %q", rr.Header().Get("Location"), tt.cfg.RedirectTo)
+			}
+
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+			cookies := rr.Result().Cookies()
+			if len(cookies) == 0 {
+				t.Fatal("expected session cookie after login")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/account", nil)
+			req.AddCookie(cookies[0])
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK || rr.Body.String() != "secret" {
+				t.Fatalf("authenticated request: status=%d body=%q", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireRecentAuthRedirectsAndReturns(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	var intended string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		if err := s.Login("alice"); err != nil {
+			t.Errorf("Login failed: %v", err)
+		}
+	})
+	mux.HandleFunc("/confirm", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		s.ConfirmPassword()
+		intended = s.IntendedURL("/")
+	})
+	mux.Handle("/settings/password", RequireRecentAuth(manager, time.Nanosecond, AuthConfig{
+		RedirectTo: "/confirm",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("change password"))
+	})))
+	handler := StartSession(manager, "mock")(mux)
+
+	do := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cookie := do("/login", nil).Result().Cookies()[0]
+	time.Sleep(time.Millisecond) // let the login confirmation go stale
+
+	rr := do("/settings/password?tab=security", cookie)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/confirm" {
+		t.Fatalf("stale confirmation: status=%d location=%q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	do("/confirm", cookie)
+	if intended != "/settings/password?tab=security" {
+		t.Fatalf("IntendedURL = %q, want the guarded URL", intended)
+	}
+}
+
+func TestRequireAuthIgnoresOffSiteIntendedURL(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	var intended string
+
+	guarded := RequireAuth(manager, AuthConfig{RedirectTo: "/login"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler := StartSession(manager, "mock")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			s, _ := manager.GetSession(r)
+			intended = s.IntendedURL("/")
+			return
+		}
+		guarded.ServeHTTP(w, r)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "//evil.example/account", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusFound)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.AddCookie(rr.Result().Cookies()[0])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if intended != "/" {
+		t.Fatalf("IntendedURL = %q, want the fallback", intended)
+	}
+
+	for uri, want := range map[string]bool{
+		"/account?tab=1":       true,
+		"/":                    true,
+		"//evil.example":       false,
+		"/\\evil.example":      false,
+		"https://evil.example": false,
+		"":                     false,
+	} {
+		if got := localPath(uri); got != want {
+			t.Errorf("localPath(%q) = %v, want %v", uri, got, want)
+		}
+	}
+}
+
+func TestRequireAuthRemembersIntendedURLOnReadOnlyRequest(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) { o.Debug = true })
+	var intended string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := h.Manager.GetSession(r)
+		if err := s.Login("alice"); err != nil {
+			t.Errorf("Login failed: %v", err)
+		}
+		intended = s.IntendedURL("/")
+	})
+	mux.Handle("/account", RequireAuth(h.Manager, AuthConfig{RedirectTo: "/login"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	handler := StartSessionWithConfig(h.Manager, Config{
+		ReadOnly: MatchMethod(http.MethodGet, http.MethodHead),
+	})(mux)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, h.NewRequest(http.MethodGet, "/account", ""))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusFound)
+	}
+	id := h.SessionID(rr)
+	if id == "" {
+		t.Fatal("expected a session cookie carrying the intended URL")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), h.NewRequest(http.MethodPost, "/login", id))
+	if intended != "/account" {
+		t.Fatalf("IntendedURL = %q, want /account", intended)
+	}
+}
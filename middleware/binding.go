@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"github.com/libtnb/sessions"
+)
+
+// BindingAction is what Binding does when a session is presented by a
+// client whose fingerprint differs from the one recorded at creation.
+type BindingAction int
+
+const (
+	// BindingReject ignores the presented session and starts a fresh one
+	// with a new ID. The stored session is left alone, so its rightful owner
+	// is not logged out by whoever replayed the cookie.
+	BindingReject BindingAction = iota
+	// BindingRegenerate keeps the session data but moves it to a new ID,
+	// destroying the presented one, logs the user out and rebinds the
+	// session to the current client. Whoever replayed a stolen cookie never
+	// gets an authenticated session, at the price of the rightful owner
+	// losing theirs.
+	BindingRegenerate
+	// BindingHook calls Binding.OnMismatch and leaves the decision to it.
+	BindingHook
+)
+
+// ErrInvalidBinding reports a Binding that cannot act on a mismatch, i.e.
+// BindingHook without OnMismatch.
+var ErrInvalidBinding = errors.New("invalid session binding configuration")
+
+// Binding binds sessions to a fingerprint of the client that created them,
+// as defense in depth against stolen session cookies. The fingerprint is
+// recorded in the session metadata when the session is first seen and
+// compared on every later request.
+type Binding struct {
+	// UserAgent includes the User-Agent header in the fingerprint.
+	UserAgent bool
+	// IPPrefix includes the client network in the fingerprint: the /24
+	// prefix for IPv4 and the /64 prefix for IPv6, so address changes within
+	// one network do not count as a mismatch.
+	IPPrefix bool
+	// ClientCert includes the TLS client certificate in the fingerprint.
+	ClientCert bool
+	// Action selects what happens on a mismatch. Defaults to BindingReject.
+	Action BindingAction
+	// OnMismatch is called on a mismatch when Action is BindingHook, with
+	// the current client's fingerprint. It may, for example, log the event,
+	// invalidate the session, or accept the client with SetFingerprint. It
+	// is required with BindingHook.
+	OnMismatch func(r *http.Request, s *sessions.Session, fingerprint string)
+}
+
+// validate reports a Binding that cannot act on a mismatch.
+func (b *Binding) validate() error {
+	if b.Action == BindingHook && b.OnMismatch == nil {
+		return fmt.Errorf("%w: BindingHook requires OnMismatch", ErrInvalidBinding)
+	}
+	return nil
+}
+
+// apply compares the request's fingerprint with the one recorded in the
+// session and acts on a mismatch. Sessions without a recorded fingerprint
+// (new sessions, or ones created before binding was enabled) are bound to
+// the current client.
+func (b *Binding) apply(r *http.Request, s *sessions.Session, clientIP string) error {
+	fingerprint := b.fingerprint(r, clientIP)
+	recorded := s.Meta().Fingerprint
+	if recorded == "" {
+		s.SetFingerprint(fingerprint)
+		return nil
+	}
+	if recorded == fingerprint {
+		return nil
+	}
+
+	switch b.Action {
+	case BindingRegenerate:
+		if err := s.Regenerate(true); err != nil {
+			return err
+		}
+		if err := s.SetUserID(""); err != nil {
+			return err
+		}
+	case BindingHook:
+		b.OnMismatch(r, s, fingerprint)
+		return nil
+	default:
+		s.Discard().SetClient(clientIP, r.UserAgent())
+	}
+	s.SetFingerprint(fingerprint)
+	return nil
+}
+
+// fingerprint hashes the selected client properties. An empty selection
+// yields a constant, which never mismatches.
+func (b *Binding) fingerprint(r *http.Request, clientIP string) string {
+	h := sha256.New()
+	if b.UserAgent {
+		h.Write([]byte("ua:" + r.UserAgent() + "\x00"))
+	}
+	if b.IPPrefix {
+		h.Write([]byte("ip:" + ipPrefix(clientIP) + "\x00"))
+	}
+	if b.ClientCert && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		h.Write([]byte("cert:" + hex.EncodeToString(sum[:]) + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ipPrefix returns the /24 (IPv4) or /64 (IPv6) network of addr, or addr
+// itself when it cannot be parsed.
+func ipPrefix(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return addr
+	}
+	ip = ip.Unmap()
+	bits := 64
+	if ip.Is4() {
+		bits = 24
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return addr
+	}
+	return prefix.String()
+}
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libtnb/sessions"
+)
+
+// bindingRoundtrip seeds a session from one user agent and replays its cookie
+// from another, returning the seeded cookie, the replayed response and what
+// the replaying handler saw under "user".
+func bindingRoundtrip(t *testing.T, manager *sessions.Manager, binding *Binding) (*http.Cookie, *httptest.ResponseRecorder, any) {
+	t.Helper()
+
+	var seen any
+	handler := StartSessionWithConfig(manager, Config{
+		Driver:  "mock",
+		Binding: binding,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		if r.URL.Path == "/seed" {
+			s.Put("user", "alice")
+			return
+		}
+		seen = s.Get("user")
+	}))
+
+	seed := httptest.NewRequest(http.MethodGet, "/seed", nil)
+	seed.Header.Set("User-Agent", "browser/1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, seed)
+	cookie := rr.Result().Cookies()[0]
+
+	replay := httptest.NewRequest(http.MethodGet, "/", nil)
+	replay.Header.Set("User-Agent", "attacker/1")
+	replay.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, replay)
+	return cookie, rr, seen
+}
+
+func TestBindingRejectStartsFreshSession(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+
+	cookie, rr, seen := bindingRoundtrip(t, manager, &Binding{UserAgent: true})
+	if seen != nil {
+		t.Fatalf("mismatching client saw session data: %v", seen)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 || cookies[0].Value == cookie.Value {
+		t.Fatal("expected a fresh session ID for the mismatching client")
+	}
+}
+
+func TestBindingRegenerateKeepsDataUnderNewID(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+
+	cookie, rr, seen := bindingRoundtrip(t, manager, &Binding{UserAgent: true, Action: BindingRegenerate})
+	if seen != "alice" {
+		t.Fatalf("regenerated session lost its data, got %v", seen)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 || cookies[0].Value == cookie.Value {
+		t.Fatal("expected the session ID to be regenerated")
+	}
+}
+
+func TestBindingRegenerateDestroysOldIDAndLogsOut(t *testing.T) {
+	driver := newMemoryDriver(false)
+	manager := buildManagerWithDriver(t, driver)
+
+	var user string
+	var data any
+	handler := StartSessionWithConfig(manager, Config{
+		Driver:  "mock",
+		Binding: &Binding{UserAgent: true, Action: BindingRegenerate},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		if r.URL.Path == "/login" {
+			if err = s.Login("victim"); err != nil {
+				t.Errorf("Login failed: %v", err)
+			}
+			s.Put("cart", 3)
+			return
+		}
+		user, data = s.UserID(), s.Get("cart")
+	}))
+
+	login := httptest.NewRequest(http.MethodPost, "/login", nil)
+	login.Header.Set("User-Agent", "browser/1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, login)
+	cookie := rr.Result().Cookies()[0]
+	oldID, _ := manager.VerifyID(cookie.Value)
+
+	replay := httptest.NewRequest(http.MethodGet, "/", nil)
+	replay.Header.Set("User-Agent", "attacker/1")
+	replay.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, replay)
+	if user != "" {
+		t.Fatalf("replayed cookie from another client is authenticated as %q", user)
+	}
+	if data != 3 {
+		t.Fatalf("regenerated session lost its data, got %v", data)
+	}
+	driver.mu.Lock()
+	_, found := driver.data[oldID]
+	driver.mu.Unlock()
+	if found {
+		t.Fatal("the replayed session ID is still in the store")
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 || cookies[0].Value == cookie.Value {
+		t.Fatal("expected the session ID to be regenerated")
+	}
+}
+
+func TestBindingHookIsCalledOnMismatch(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+
+	called := 0
+	_, _, seen := bindingRoundtrip(t, manager, &Binding{
+		UserAgent: true,
+		Action:    BindingHook,
+		OnMismatch: func(r *http.Request, s *sessions.Session, fingerprint string) {
+			called++
+			if fingerprint == s.Meta().Fingerprint {
+				t.Error("hook received the recorded fingerprint instead of the client's")
+			}
+		},
+	})
+	if called != 1 {
+		t.Fatalf("OnMismatch called %d times, want 1", called)
+	}
+	if seen != "alice" {
+		t.Fatalf("hook mode must leave the session alone, got %v", seen)
+	}
+}
+
+func TestConfigValidateRequiresBindingHookCallback(t *testing.T) {
+	err := Config{Binding: &Binding{UserAgent: true, Action: BindingHook}}.Validate()
+	if !errors.Is(err, ErrInvalidBinding) {
+		t.Fatalf("Validate() = %v, want ErrInvalidBinding", err)
+	}
+	err = Config{Binding: &Binding{UserAgent: true, Action: BindingHook, OnMismatch: func(*http.Request, *sessions.Session, string) {}}}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestBindingIPPrefixToleratesAddressChangesWithinNetwork(t *testing.T) {
+	b := &Binding{IPPrefix: true}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if b.fingerprint(r, "192.0.2.10") != b.fingerprint(r, "192.0.2.200") {
+		t.Fatal("addresses in the same /24 must share a fingerprint")
+	}
+	if b.fingerprint(r, "192.0.2.10") == b.fingerprint(r, "198.51.100.10") {
+		t.Fatal("addresses in different /24s must not share a fingerprint")
+	}
+	if b.fingerprint(r, "2001:db8::1") != b.fingerprint(r, "2001:db8::ffff") {
+		t.Fatal("addresses in the same /64 must share a fingerprint")
+	}
+}
+
+func TestBindingRejectDropsAuthentication(t *testing.T) {
+	driver := newMemoryDriver(false)
+	manager := buildManagerWithDriver(t, driver)
+
+	var user string
+	handler := StartSessionWithConfig(manager, Config{
+		Driver:  "mock",
+		Binding: &Binding{UserAgent: true},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		if r.URL.Path == "/login" {
+			if err = s.Login("victim"); err != nil {
+				t.Errorf("Login failed: %v", err)
+			}
+			return
+		}
+		user = s.UserID()
+	}))
+
+	login := httptest.NewRequest(http.MethodPost, "/login", nil)
+	login.Header.Set("User-Agent", "browser/1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, login)
+	cookie := rr.Result().Cookies()[0]
+
+	replay := httptest.NewRequest(http.MethodGet, "/", nil)
+	replay.Header.Set("User-Agent", "attacker/1")
+	replay.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, replay)
+	if user != "" {
+		t.Fatalf("replayed cookie from another client is authenticated as %q", user)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 || cookies[0].Value == cookie.Value {
+		t.Fatal("expected a fresh session ID for the mismatching client")
+	}
+
+	// The owner's session is untouched
+	owner := httptest.NewRequest(http.MethodGet, "/", nil)
+	owner.Header.Set("User-Agent", "browser/1")
+	owner.AddCookie(cookie)
+	handler.ServeHTTP(httptest.NewRecorder(), owner)
+	if user != "victim" {
+		t.Fatalf("owner's session lost its user, got %q", user)
+	}
+}
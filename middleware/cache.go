@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// cacheDirectives returns the lower-cased directive names of the response's
+// Cache-Control header.
+func cacheDirectives(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Cache-Control") {
+		for directive := range strings.SplitSeq(value, ",") {
+			name, _, _ := strings.Cut(directive, "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// publiclyCacheable reports whether the handler marked the response as
+// storable by shared caches.
+func publiclyCacheable(h http.Header) bool {
+	directives := cacheDirectives(h)
+	return slices.Contains(directives, "public") || slices.Contains(directives, "s-maxage")
+}
+
+// removeSetCookie drops the response's Set-Cookie headers for the cookie
+// called name, keeping the others.
+func removeSetCookie(h http.Header, name string) {
+	values := h.Values("Set-Cookie")
+	kept := slices.DeleteFunc(slices.Clone(values), func(value string) bool {
+		cookie, err := http.ParseSetCookie(value)
+		return err == nil && cookie.Name == name
+	})
+	if len(kept) == len(values) {
+		return
+	}
+	h.Del("Set-Cookie")
+	for _, value := range kept {
+		h.Add("Set-Cookie", value)
+	}
+}
+
+// guardCache keeps shared caches from storing a response that carries a
+// session cookie: directive is added to Cache-Control unless the handler
+// already made the response private, and Cookie is added to Vary.
+func guardCache(h http.Header, directive string) {
+	directives := cacheDirectives(h)
+	if !slices.Contains(directives, "private") && !slices.Contains(directives, "no-store") {
+		if current := h.Get("Cache-Control"); current != "" {
+			directive += ", " + current
+		}
+		h.Set("Cache-Control", directive)
+	}
+
+	for _, value := range h.Values("Vary") {
+		for field := range strings.SplitSeq(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, "Cookie") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Cookie")
+}
+
+// cookieStale reports whether the session cookie must be (re)sent: always,
+// unless cfg.CookieRefresh is set, in which case only when the client did
+// not present the current cookie value or the expiry would move by at
+// least CookieRefresh.
+func cookieStale(manager *sessions.Manager, cfg Config, s *sessions.Session, presented string, expiresAt time.Time) bool {
+	if cfg.CookieRefresh <= 0 {
+		return true
+	}
+	if presented != manager.SignID(s.GetID()) {
+		return true
+	}
+	return expiresAt.Sub(s.Meta().CookieExpiresAt) >= cfg.CookieRefresh
+}
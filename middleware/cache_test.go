@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestGuardCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		vary         string
+		wantCache    string
+		wantVary     string
+	}{
+		{"empty", "", "", "private", "Cookie"},
+		{"max-age", "max-age=60", "Accept-Encoding", "private, max-age=60", "Accept-Encoding, Cookie"},
+		{"already private", "private, max-age=60", "cookie", "private, max-age=60", "cookie"},
+		{"no-store", "No-Store", "*", "No-Store", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.cacheControl != "" {
+				h.Set("Cache-Control", tt.cacheControl)
+			}
+			if tt.vary != "" {
+				h.Set("Vary", tt.vary)
+			}
+			guardCache(h, "private")
+			if got := h.Get("Cache-Control"); got != tt.wantCache {
+				t.Fatalf("Cache-Control = %q, want %q", got, tt.wantCache)
+			}
+			if got := strings.Join(h.Values("Vary"), ", "); got != tt.wantVary {
+				t.Fatalf("Vary = %q, want %q", got, tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestPubliclyCacheable(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":                         false,
+		"private, max-age=60":      false,
+		"public, max-age=3600":     true,
+		"max-age=60, S-MaxAge=600": true,
+		"no-cache":                 false,
+	} {
+		h := http.Header{}
+		if value != "" {
+			h.Set("Cache-Control", value)
+		}
+		if got := publiclyCacheable(h); got != want {
+			t.Errorf("publiclyCacheable(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestStartSessionCacheControl(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{CacheControl: "private"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=600")
+			http.SetCookie(w, &http.Cookie{Name: "tracking", Value: "1"})
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", ""))
+	if h.SessionID(w) == "" {
+		t.Fatal("expected a session cookie")
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private" {
+		t.Fatalf("Cache-Control = %q, want private", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Cookie" {
+		t.Fatalf("Vary = %q, want Cookie", got)
+	}
+
+	id := h.Seed(nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/public", id))
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "tracking" {
+		t.Fatalf("public response carries cookies %v, want only the handler's", cookies)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=600" {
+		t.Fatalf("Cache-Control = %q, want the handler's", got)
+	}
+	h.AssertStored(id)
+}
+
+func TestStartSessionLoginOnPublicResponseSendsCookie(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{CacheControl: "private"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		if r.URL.Path == "/login" {
+			s, _ := h.Manager.GetSession(r)
+			if err := s.Login("alice"); err != nil {
+				t.Errorf("Login failed: %v", err)
+			}
+		}
+	}))
+
+	id := h.Seed(nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/login", id))
+	moved := h.SessionID(w)
+	if moved == "" || moved == id {
+		t.Fatalf("session cookie after Login = %q, want the new ID", moved)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Fatalf("Cache-Control = %q, want the response made private", got)
+	}
+	h.AssertNotStored(id)
+	h.AssertStored(moved)
+}
+
+func TestStartSessionCookieRefresh(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) { o.Lifetime = 120 })
+	handler := StartSessionWithConfig(h.Manager, Config{CookieRefresh: 10 * time.Minute})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve := func(id string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+		return h.SessionID(w)
+	}
+
+	id := serve("")
+	if id == "" {
+		t.Fatal("expected a cookie for a new session")
+	}
+	h.Clock.Advance(5 * time.Minute)
+	if got := serve(id); got != "" {
+		t.Fatal("cookie re-sent although its expiry moved by less than CookieRefresh")
+	}
+	h.Clock.Advance(6 * time.Minute)
+	if got := serve(id); got != id {
+		t.Fatalf("cookie for %q = %q, want it re-sent after CookieRefresh", id, got)
+	}
+	if got := serve(id); got != "" {
+		t.Fatal("cookie re-sent right after a refresh")
+	}
+
+	// A client without the cookie always gets it
+	if got := serve(""); got == "" {
+		t.Fatal("expected a cookie for a client without one")
+	}
+}
+
+func TestStartSessionCookieRefreshOnReadOnlyRoutes(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) { o.Lifetime = 120 })
+	handler := StartSessionWithConfig(h.Manager, Config{
+		CacheControl:  "private",
+		CookieRefresh: 10 * time.Minute,
+		ReadOnly:      MatchMethod(http.MethodGet),
+	})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve := func(method, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(method, "/", id))
+		return w
+	}
+
+	id := h.SessionID(serve(http.MethodPost, ""))
+	h.Clock.Advance(11 * time.Minute)
+	if got := h.SessionID(serve(http.MethodGet, id)); got != id {
+		t.Fatalf("cookie for %q = %q, want it re-sent after CookieRefresh", id, got)
+	}
+	w := serve(http.MethodGet, id)
+	if got := h.SessionID(w); got != "" {
+		t.Fatal("read-only route re-sent the cookie right after a refresh")
+	}
+	if got := w.Header().Get("Cache-Control"); got != "" {
+		t.Fatalf("Cache-Control = %q, want none without a cookie", got)
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// ConcurrencyLimitConfig configures the ConcurrencyLimit middleware.
+type ConcurrencyLimitConfig struct {
+	// Limit is how many requests of one session are served at once. It
+	// must be positive.
+	Limit int
+	// Wait is how long a request over the limit queues for a slot before it
+	// is rejected. 0 rejects it right away.
+	Wait time.Duration
+	// ClientIP returns the address that keys requests without a stored
+	// session. Defaults to the address StartSession determined with
+	// Config.ClientIP, or the host part of r.RemoteAddr without
+	// StartSession in front.
+	ClientIP func(*http.Request) string
+}
+
+// ConcurrencyLimit caps the requests of one session that are in flight at
+// the same time, e.g. dozens of tabs hitting an expensive endpoint at once.
+// Requests are keyed like RateLimit's: by the session the client already
+// holds, or else by client IP. A request over the limit waits up to
+// cfg.Wait for a slot, or until the client goes away, and is then rejected
+// with 429 Too Many Requests.
+//
+// Install it inside StartSession. Slots are held with
+// Manager.AcquireSessionSemaphore, so the limit applies per process.
+// ConcurrencyLimit panics if cfg.Limit is not positive.
+func ConcurrencyLimit(manager *sessions.Manager, cfg ConcurrencyLimitConfig) func(next http.Handler) http.Handler {
+	if cfg.Limit <= 0 {
+		panic(fmt.Sprintf("middleware: ConcurrencyLimit limit %d is not positive", cfg.Limit))
+	}
+	clientIP := clientIPFunc(cfg.ClientIP)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "concurrency:" + limitKey(manager, r, clientIP)
+
+			ctx, cancel := context.WithTimeout(r.Context(), cfg.Wait)
+			err := manager.AcquireSessionSemaphore(ctx, key, cfg.Limit)
+			cancel()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			defer manager.ReleaseSessionSemaphore(key)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
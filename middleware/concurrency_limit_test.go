@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	h := sessionstest.New(t)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := StartSession(h.Manager)(ConcurrencyLimit(h.Manager, ConcurrencyLimitConfig{
+		Limit: 1,
+		Wait:  10 * time.Millisecond,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+	})))
+
+	serve := func(path, id string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(http.MethodGet, path, id))
+		return w.Code
+	}
+
+	alice, bob := h.Seed(nil), h.Seed(nil)
+	slow := make(chan int)
+	go func() { slow <- serve("/slow", alice) }()
+	<-entered
+
+	if code := serve("/", alice); code != http.StatusTooManyRequests {
+		t.Fatalf("second concurrent request: status %d, want 429", code)
+	}
+	if code := serve("/", bob); code != http.StatusOK {
+		t.Fatalf("other session: status %d, want 200", code)
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Fatalf("slow request: status %d, want 200", code)
+	}
+	if code := serve("/", alice); code != http.StatusOK {
+		t.Fatalf("request after the slot was released: status %d, want 200", code)
+	}
+}
+
+func TestConcurrencyLimitQueues(t *testing.T) {
+	h := sessionstest.New(t)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := StartSession(h.Manager)(ConcurrencyLimit(h.Manager, ConcurrencyLimitConfig{
+		Limit: 1,
+		Wait:  time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+	})))
+
+	id := h.Seed(nil)
+	codes := make(chan int, 2)
+	serve := func(path string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(http.MethodGet, path, id))
+		codes <- w.Code
+	}
+	go serve("/slow")
+	<-entered
+	go serve("/")
+
+	select {
+	case code := <-codes:
+		t.Fatalf("queued request finished with %d while the slot was held", code)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	for range 2 {
+		if code := <-codes; code != http.StatusOK {
+			t.Fatalf("status %d, want 200", code)
+		}
+	}
+}
+
+func TestConcurrencyLimitUsesStartSessionClientIP(t *testing.T) {
+	h := sessionstest.New(t)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := StartSessionWithConfig(h.Manager, Config{
+		ClientIP: func(r *http.Request) string { return r.Header.Get("X-Real-IP") },
+	})(ConcurrencyLimit(h.Manager, ConcurrencyLimitConfig{
+		Limit: 1,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+	})))
+
+	// Behind a proxy every request comes from the proxy's address
+	serve := func(path, ip string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	slow := make(chan int)
+	go func() { slow <- serve("/slow", "192.0.2.1") }()
+	<-entered
+
+	if code := serve("/", "192.0.2.2"); code != http.StatusOK {
+		t.Fatalf("request from another client behind the proxy: status %d, want 200", code)
+	}
+	if code := serve("/", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("concurrent request from the same client: status %d, want 429", code)
+	}
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Fatalf("slow request: status %d, want 200", code)
+	}
+}
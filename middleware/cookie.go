@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// Cookie name prefixes that browsers tie to cookie attributes; see
+// Config.CookiePrefix.
+const (
+	// HostPrefix cookies must be Secure, have Path=/ and no Domain, so they
+	// are bound to the exact host that set them.
+	HostPrefix = "__Host-"
+	// SecurePrefix cookies must be Secure.
+	SecurePrefix = "__Secure-"
+)
+
+// ErrInvalidCookie reports a cookie configuration browsers would reject or
+// that contradicts itself, e.g. SameSite=None without Secure.
+var ErrInvalidCookie = errors.New("invalid session cookie configuration")
+
+// Validate checks the cookies the configuration produces, as they would be
+// sent over TLS, for attribute combinations browsers reject on any request,
+// and that Binding can act on a mismatch. StartSessionWithConfig panics on a configuration that fails Validate.
+// Cookies that are only invalid over plain HTTP, because SameSite=None,
+// Partitioned or a cookie prefix relies on the request's TLS for Secure, are
+// checked per request instead.
+func (c Config) Validate() error {
+	switch c.CookiePrefix {
+	case "", HostPrefix, SecurePrefix:
+	default:
+		return fmt.Errorf("%w: CookiePrefix %q is neither %q nor %q", ErrInvalidCookie, c.CookiePrefix, HostPrefix, SecurePrefix)
+	}
+
+	for _, name := range []string{sessions.CookieName, sessions.RememberCookieName} {
+		cookie := prepareCookie(c, true, name, "", 1, time.Now())
+		if err := validateCookie(cookie); err != nil {
+			return err
+		}
+	}
+	if c.Binding != nil {
+		return c.Binding.validate()
+	}
+	return nil
+}
+
+// validateCookie reports attribute combinations browsers reject.
+func validateCookie(cookie *http.Cookie) error {
+	switch {
+	case strings.HasPrefix(cookie.Name, HostPrefix) && (!cookie.Secure || cookie.Path != "/" || cookie.Domain != ""):
+		return fmt.Errorf("%w: %s cookie %q requires Secure, Path=/ and no Domain", ErrInvalidCookie, HostPrefix, cookie.Name)
+	case strings.HasPrefix(cookie.Name, SecurePrefix) && !cookie.Secure:
+		return fmt.Errorf("%w: %s cookie %q requires Secure", ErrInvalidCookie, SecurePrefix, cookie.Name)
+	case cookie.SameSite == http.SameSiteNoneMode && !cookie.Secure:
+		return fmt.Errorf("%w: SameSite=None requires Secure", ErrInvalidCookie)
+	case cookie.Partitioned && !cookie.Secure:
+		return fmt.Errorf("%w: Partitioned requires Secure", ErrInvalidCookie)
+	}
+	return nil
+}
+
+// cookieName returns the name the cookie called name is sent and looked up
+// under: CookiePrefix + name, unless the Cookie callback renames it.
+func cookieName(cfg Config, name string) string {
+	return prepareCookie(cfg, false, name, "", 1, time.Now()).Name
+}
+
+// newCookie prepares a cookie with the middleware defaults, expiring after
+// the given number of minutes, customized by cfg.Cookie.
+func newCookie(manager *sessions.Manager, cfg Config, r *http.Request, name, value string, minutes int) *http.Cookie {
+	return prepareCookie(cfg, r.TLS != nil, name, value, minutes, manager.Clock().Now())
+}
+
+// setCookie adds cookie to the response, unless browsers would reject it:
+// then the misconfiguration is logged instead.
+func setCookie(manager *sessions.Manager, w http.ResponseWriter, cookie *http.Cookie) {
+	if err := validateCookie(cookie); err != nil {
+		manager.Logger().Error("session cookie not sent", "cookie", cookie.Name, "error", err)
+		return
+	}
+	http.SetCookie(w, cookie)
+}
+
+// prepareCookie builds the cookie called name: the middleware defaults, the
+// prefix and Partitioned attributes, then cfg.Cookie.
+func prepareCookie(cfg Config, secure bool, name, value string, minutes int, now time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:        cfg.CookiePrefix + name,
+		Value:       value,
+		MaxAge:      minutes * 60,
+		Expires:     now.Add(time.Duration(minutes) * time.Minute),
+		Path:        "/",
+		HttpOnly:    true,
+		Secure:      secure || cfg.CookiePrefix != "" || cfg.Partitioned,
+		SameSite:    http.SameSiteLaxMode,
+		Partitioned: cfg.Partitioned,
+	}
+	if cfg.Cookie != nil {
+		cfg.Cookie(cookie)
+	}
+	return cookie
+}
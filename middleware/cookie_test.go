@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"default", Config{}, false},
+		{"host prefix", Config{CookiePrefix: HostPrefix}, false},
+		{"secure prefix with domain", Config{CookiePrefix: SecurePrefix, Cookie: func(c *http.Cookie) { c.Domain = "example.com" }}, false},
+		{"unknown prefix", Config{CookiePrefix: "__Foo-"}, true},
+		{"host prefix with domain", Config{CookiePrefix: HostPrefix, Cookie: func(c *http.Cookie) { c.Domain = "example.com" }}, true},
+		{"host prefix with path", Config{CookiePrefix: HostPrefix, Cookie: func(c *http.Cookie) { c.Path = "/app" }}, true},
+		{"secure prefix made insecure", Config{CookiePrefix: SecurePrefix, Cookie: func(c *http.Cookie) { c.Secure = false }}, true},
+		{"renamed to host prefix", Config{Cookie: func(c *http.Cookie) { c.Name = HostPrefix + c.Name }}, false},
+		{"renamed to host prefix with path", Config{Cookie: func(c *http.Cookie) { c.Name = HostPrefix + c.Name; c.Path = "/app" }}, true},
+		{"samesite none over tls", Config{Cookie: func(c *http.Cookie) { c.SameSite = http.SameSiteNoneMode }}, false},
+		{"samesite none insecure", Config{Cookie: func(c *http.Cookie) { c.SameSite = http.SameSiteNoneMode; c.Secure = false }}, true},
+		{"samesite none secure", Config{Cookie: func(c *http.Cookie) { c.SameSite = http.SameSiteNoneMode; c.Secure = true }}, false},
+		{"partitioned", Config{Partitioned: true, Cookie: func(c *http.Cookie) { c.SameSite = http.SameSiteNoneMode }}, false},
+		{"partitioned made insecure", Config{Partitioned: true, Cookie: func(c *http.Cookie) { c.Secure = false }}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCookie) {
+				t.Fatalf("Validate() = %v, want ErrInvalidCookie", err)
+			}
+		})
+	}
+}
+
+func TestStartSessionWithConfigPanicsOnInvalidCookie(t *testing.T) {
+	h := sessionstest.New(t)
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrInvalidCookie) {
+			t.Fatalf("recovered %v, want ErrInvalidCookie", err)
+		}
+	}()
+	StartSessionWithConfig(h.Manager, Config{CookiePrefix: "__Foo-"})
+}
+
+func TestStartSessionDropsCookieInvalidOverPlainHTTP(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{
+		Cookie: func(c *http.Cookie) { c.SameSite = http.SameSiteNoneMode },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := h.NewRequest(http.MethodGet, "/", "")
+	req.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteNoneMode {
+		t.Fatalf("TLS response cookies = %v, want a Secure SameSite=None session cookie", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", ""))
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Fatalf("plain HTTP response cookies = %v, want none", cookies)
+	}
+}
+
+func TestStartSessionCookiePrefix(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{
+		CookiePrefix: HostPrefix,
+		Partitioned:  true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := h.Manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		s.Put("views", s.Get("views", 0).(int)+1)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got cookies %v, want one", cookies)
+	}
+	c := cookies[0]
+	if c.Name != HostPrefix+sessions.CookieName || !c.Secure || c.Path != "/" || c.Domain != "" || !c.Partitioned {
+		t.Fatalf("cookie = %+v, want a Secure, Partitioned __Host- cookie on /", c)
+	}
+
+	// The prefixed cookie is read back on the next request
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	h.AssertValue(c.Value, "views", 2)
+}
+
+func TestStartSessionCookieRenamedByCallback(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{
+		Cookie: func(c *http.Cookie) { c.Name = "app_session" },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := h.Manager.GetSession(r)
+		s.Put("k", "v")
+	}))
+
+	id := h.Seed(nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "app_session", Value: id})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "app_session" || cookies[0].Value != id {
+		t.Fatalf("got cookies %v, want app_session=%s", cookies, id)
+	}
+	h.AssertValue(id, "k", "v")
+}
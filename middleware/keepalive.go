@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// IdleStatus is the JSON body served by SessionStatus and Keepalive.
+type IdleStatus struct {
+	// Active is false when the request carries no stored session, e.g.
+	// because it expired.
+	Active bool `json:"active"`
+	// ExpiresAt is when the session expires unless it is used again.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// IdleRemaining is the time left until ExpiresAt, in seconds.
+	IdleRemaining int64 `json:"idle_remaining"`
+}
+
+// SessionStatus returns a handler reporting how long the request's session
+// has left as an IdleStatus, so single-page apps can warn users before they
+// are logged out. The session is only peeked at (Session.Peek): it is not
+// refreshed, and its flash data survives for the next page. Mount it behind
+// StartSession.
+func SessionStatus(manager *sessions.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.Peek()
+
+		expiresAt, err := s.ExpiresAt()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeIdleStatus(w, manager, expiresAt)
+	})
+}
+
+// Keepalive returns a handler that refreshes the request's session, even
+// within the manager's TouchInterval, and reports the new IdleStatus. A
+// request without a stored session is answered as inactive and does not
+// create one. Mount it behind StartSession, preferably for POST only.
+func Keepalive(manager *sessions.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !s.IsPersisted() {
+			s.Peek()
+			writeIdleStatus(w, manager, time.Time{})
+			return
+		}
+
+		// StartSession saves, and with that touches, the session once this
+		// handler returns
+		s.Touch()
+		writeIdleStatus(w, manager, manager.Clock().Now().Add(time.Duration(manager.Lifetime)*time.Minute))
+	})
+}
+
+// writeIdleStatus answers with the IdleStatus of a session expiring at
+// expiresAt, or an inactive one for the zero time.
+func writeIdleStatus(w http.ResponseWriter, manager *sessions.Manager, expiresAt time.Time) {
+	var status IdleStatus
+	if remaining := expiresAt.Sub(manager.Clock().Now()); !expiresAt.IsZero() && remaining > 0 {
+		status = IdleStatus{
+			Active:        true,
+			ExpiresAt:     expiresAt.UTC(),
+			IdleRemaining: int64(remaining / time.Second),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestSessionStatusAndKeepalive(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) {
+		o.Lifetime = 60
+		o.TouchInterval = 10
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /session/status", SessionStatus(h.Manager))
+	mux.Handle("POST /session/keepalive", Keepalive(h.Manager))
+	handler := StartSession(h.Manager)(mux)
+
+	request := func(method, target, id string) IdleStatus {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(method, target, id))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d", method, target, w.Code)
+		}
+		if method == http.MethodGet && len(w.Result().Cookies()) != 0 {
+			t.Fatalf("status response re-sent the session cookie: %v", w.Result().Cookies())
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Fatalf("Cache-Control = %q, want no-store", got)
+		}
+		var status IdleStatus
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("decoding status failed: %v", err)
+		}
+		return status
+	}
+
+	id := h.Seed(nil)
+	h.Clock.Advance(20 * time.Minute)
+	for range 2 {
+		// Asking does not refresh the session
+		status := request(http.MethodGet, "/session/status", id)
+		if !status.Active || status.IdleRemaining != 40*60 {
+			t.Fatalf("status = %+v, want active with 40 minutes left", status)
+		}
+	}
+
+	status := request(http.MethodPost, "/session/keepalive", id)
+	if !status.Active || status.IdleRemaining != 60*60 {
+		t.Fatalf("keepalive = %+v, want active with 60 minutes left", status)
+	}
+
+	// An explicit keepalive refreshes even within TouchInterval, touching
+	// rather than rewriting the unchanged session
+	h.Clock.Advance(5 * time.Minute)
+	writes := h.Driver.Writes()
+	if status = request(http.MethodPost, "/session/keepalive", id); status.IdleRemaining != 60*60 {
+		t.Fatalf("keepalive within TouchInterval = %+v, want 60 minutes left", status)
+	}
+	if h.Driver.Writes() != writes {
+		t.Fatal("keepalive rewrote the session instead of touching it")
+	}
+	if status = request(http.MethodGet, "/session/status", id); status.IdleRemaining != 60*60 {
+		t.Fatalf("status after keepalive = %+v, want 60 minutes left", status)
+	}
+
+	h.Clock.Advance(61 * time.Minute)
+	if status = request(http.MethodGet, "/session/status", id); status.Active || status.IdleRemaining != 0 {
+		t.Fatalf("status of expired session = %+v, want inactive", status)
+	}
+	writes = h.Driver.Writes()
+	if status = request(http.MethodPost, "/session/keepalive", id); status.Active {
+		t.Fatalf("keepalive of expired session = %+v, want inactive", status)
+	}
+	if h.Driver.Writes() != writes {
+		t.Fatal("keepalive without a stored session created one")
+	}
+}
+
+func TestSessionStatusLeavesFlashAndExpiry(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) {
+		o.Lifetime = 60
+		o.TouchInterval = 10
+	})
+	var notice any
+	mux := http.NewServeMux()
+	mux.Handle("GET /session/status", SessionStatus(h.Manager))
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := h.Manager.GetSession(r)
+		notice = s.Get("notice")
+	})
+	handler := StartSession(h.Manager)(mux)
+
+	id := h.Seed(func(s *sessions.Session) { s.Flash("notice", "saved") })
+	h.Clock.Advance(20 * time.Minute)
+	writes := h.Driver.Writes()
+	for range 2 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/session/status", id))
+		var status IdleStatus
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("decoding status failed: %v", err)
+		}
+		if status.IdleRemaining != 40*60 {
+			t.Fatalf("status = %+v, want 40 minutes left", status)
+		}
+	}
+	if h.Driver.Writes() != writes {
+		t.Fatal("status request wrote the session")
+	}
+	if at, _, _ := h.Driver.LastActivity(id); !at.Before(h.Clock.Now()) {
+		t.Fatal("status request touched the session")
+	}
+
+	// The flash is still there for the next page
+	handler.ServeHTTP(httptest.NewRecorder(), h.NewRequest(http.MethodGet, "/", id))
+	if notice != "saved" {
+		t.Fatalf("flash after status requests = %#v, want %q", notice, "saved")
+	}
+}
@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/driver"
+)
+
+// RateLimitConfig configures the RateLimit middleware.
+type RateLimitConfig struct {
+	// Rate is the sustained number of requests per second allowed per key.
+	// It must be positive.
+	Rate float64
+	// Burst is the number of requests a key may make at once, the size of
+	// its token bucket. Defaults to 1.
+	Burst int
+	// Store keeps the token buckets; share one between replicas to enforce
+	// the limit across them. Bucket keys are 32 hexadecimal characters, so
+	// any driver accepting sessionid.Default IDs works. Register it with
+	// Manager.Extend under its own name to have idle buckets garbage
+	// collected (the manager's Lifetime must then exceed Burst/Rate
+	// seconds). Defaults to an in-process store.
+	Store driver.Driver
+	// ClientIP returns the address that keys requests without a stored
+	// session. Defaults to the address StartSession determined with
+	// Config.ClientIP, or the host part of r.RemoteAddr without
+	// StartSession in front.
+	ClientIP func(*http.Request) string
+}
+
+// RateLimit limits requests per session with a token bucket per session
+// ID: each request takes a token, and tokens refill at Rate per second up to
+// Burst. Requests without a session the client already holds (no
+// StartSession in front, skipped, or a first visit) are keyed by client IP
+// instead, so a client cannot escape the limit by dropping its cookie.
+// Requests over the limit get 429 Too Many Requests with Retry-After.
+//
+// Install it inside StartSession. Concurrent requests of one key are
+// serialized with Manager.LockSession; replicas sharing a Store may
+// briefly admit a few requests more than the limit. Store failures are
+// logged and let the request through. RateLimit panics if cfg.Rate is not
+// positive.
+func RateLimit(manager *sessions.Manager, cfg RateLimitConfig) func(next http.Handler) http.Handler {
+	if cfg.Rate <= 0 {
+		panic(fmt.Sprintf("middleware: RateLimit rate %v is not positive", cfg.Rate))
+	}
+	burst := float64(max(cfg.Burst, 1))
+	store := cfg.Store
+	if store == nil {
+		// A bucket idle for Burst/Rate seconds is full again, as good as gone
+		store = newMemoryStore(manager, time.Duration(burst/cfg.Rate*float64(time.Second)))
+	}
+	clientIP := clientIPFunc(cfg.ClientIP)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := bucketKey(limitKey(manager, r, clientIP))
+			wait, err := takeToken(manager, store, key, cfg.Rate, burst)
+			if err != nil {
+				manager.Logger().Error("rate limit store failed", "error", err)
+			}
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitKey identifies the client a limit applies to: the session the client
+// already holds, or else its IP.
+func limitKey(manager *sessions.Manager, r *http.Request, clientIP func(*http.Request) string) string {
+	if s, err := manager.GetSession(r); err == nil && s.IsPersisted() {
+		return "session:" + s.GetID()
+	}
+	return "ip:" + clientIP(r)
+}
+
+// bucketKey maps a rate limit key to a store key shaped like a default
+// session ID.
+func bucketKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// takeToken takes a token from the bucket stored under key and returns 0,
+// or how long until a token is available when the bucket is empty.
+func takeToken(manager *sessions.Manager, store driver.Driver, key string, rate, burst float64) (time.Duration, error) {
+	manager.LockSession("ratelimit:" + key)
+	defer manager.UnlockSession("ratelimit:" + key)
+
+	now := manager.Clock().Now()
+	tokens := burst
+	data, found, err := store.Read(key)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		if stored, at, ok := parseBucket(data); ok {
+			tokens = min(burst, stored+max(now.Sub(at).Seconds(), 0)*rate)
+		}
+	}
+
+	if tokens < 1 {
+		return time.Duration((1 - tokens) / rate * float64(time.Second)), nil
+	}
+	return 0, store.Write(key, formatBucket(tokens-1, now))
+}
+
+// formatBucket encodes a bucket as "<tokens> <unix nanoseconds>".
+func formatBucket(tokens float64, at time.Time) string {
+	return strconv.FormatFloat(tokens, 'g', -1, 64) + " " + strconv.FormatInt(at.UnixNano(), 10)
+}
+
+func parseBucket(data string) (float64, time.Time, bool) {
+	tokensField, atField, ok := strings.Cut(data, " ")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	tokens, err := strconv.ParseFloat(tokensField, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	at, err := strconv.ParseInt(atField, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return tokens, time.Unix(0, at), true
+}
+
+// memoryStore is the in-process bucket store. Buckets not written for
+// maxIdle are dropped: a sweep runs whenever the store has doubled in size
+// since the previous one.
+type memoryStore struct {
+	mu        sync.Mutex
+	manager   *sessions.Manager
+	maxIdle   time.Duration
+	entries   map[string]memoryEntry
+	sweepSize int
+}
+
+type memoryEntry struct {
+	data string
+	at   time.Time
+}
+
+func newMemoryStore(manager *sessions.Manager, maxIdle time.Duration) *memoryStore {
+	return &memoryStore{
+		manager:   manager,
+		maxIdle:   maxIdle,
+		entries:   make(map[string]memoryEntry),
+		sweepSize: 1024,
+	}
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+func (m *memoryStore) Destroy(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+	return nil
+}
+
+func (m *memoryStore) Gc(maxLifetime int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(time.Duration(maxLifetime) * time.Second)
+	return nil
+}
+
+func (m *memoryStore) Read(id string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[id]
+	return entry.data, ok, nil
+}
+
+func (m *memoryStore) Touch(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[id]
+	if ok {
+		entry.at = m.manager.Clock().Now()
+		m.entries[id] = entry
+	}
+	return ok, nil
+}
+
+func (m *memoryStore) Write(id string, data string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[id] = memoryEntry{data: data, at: m.manager.Clock().Now()}
+	if len(m.entries) >= m.sweepSize {
+		m.sweep(m.maxIdle)
+		m.sweepSize = max(2*len(m.entries), 1024)
+	}
+	return nil
+}
+
+// sweep drops the entries not written for maxIdle.
+func (m *memoryStore) sweep(maxIdle time.Duration) {
+	cutoff := m.manager.Clock().Now().Add(-maxIdle)
+	for id, entry := range m.entries {
+		if entry.at.Before(cutoff) {
+			delete(m.entries, id)
+		}
+	}
+}
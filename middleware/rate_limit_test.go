@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestRateLimitBySession(t *testing.T) {
+	h := sessionstest.New(t)
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	handler := StartSession(h.Manager)(RateLimit(h.Manager, RateLimitConfig{Rate: 1, Burst: 2})(ok))
+
+	serve := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+		return w
+	}
+
+	alice, bob := h.Seed(nil), h.Seed(nil)
+	for range 2 {
+		if w := serve(alice); w.Code != http.StatusOK {
+			t.Fatalf("request within burst: status %d, want 200", w.Code)
+		}
+	}
+	w := serve(alice)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over burst: status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want 1", got)
+	}
+	if w := serve(bob); w.Code != http.StatusOK {
+		t.Fatalf("other session: status %d, want 200", w.Code)
+	}
+
+	h.Clock.Advance(time.Second)
+	if w := serve(alice); w.Code != http.StatusOK {
+		t.Fatalf("after refill: status %d, want 200", w.Code)
+	}
+	if w := serve(alice); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("after one refilled token: status %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitFallsBackToClientIP(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSession(h.Manager)(RateLimit(h.Manager, RateLimitConfig{
+		Rate:  0.1,
+		Store: sessionstest.NewDriver(h.Clock, 10),
+	})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	serve := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Cookieless requests get a fresh session each time; they share the
+	// bucket of their address
+	if code := serve("192.0.2.1"); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+	if code := serve("192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request from the same address: status %d, want 429", code)
+	}
+	if code := serve("192.0.2.2"); code != http.StatusOK {
+		t.Fatalf("request from another address: status %d, want 200", code)
+	}
+}
+
+func TestRateLimitUsesStartSessionClientIP(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := StartSessionWithConfig(h.Manager, Config{
+		ClientIP: func(r *http.Request) string { return r.Header.Get("X-Real-IP") },
+	})(RateLimit(h.Manager, RateLimitConfig{
+		Rate:  0.1,
+		Store: sessionstest.NewDriver(h.Clock, 10),
+	})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	// Behind a proxy every request comes from the proxy's address
+	serve := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("192.0.2.1"); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+	if code := serve("192.0.2.2"); code != http.StatusOK {
+		t.Fatalf("request from another client behind the proxy: status %d, want 200", code)
+	}
+	if code := serve("192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request from the same client: status %d, want 429", code)
+	}
+}
+
+func TestRateLimitMemoryStoreSweepsIdleBuckets(t *testing.T) {
+	h := sessionstest.New(t)
+	store := newMemoryStore(h.Manager, time.Minute)
+	store.sweepSize = 4
+
+	for _, id := range []string{"a", "b", "c"} {
+		_ = store.Write(id, "0 0")
+	}
+	h.Clock.Advance(2 * time.Minute)
+	_ = store.Write("d", "0 0")
+
+	if _, found, _ := store.Read("a"); found {
+		t.Fatal("idle bucket survived the sweep")
+	}
+	if _, found, _ := store.Read("d"); !found {
+		t.Fatal("fresh bucket was swept")
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// recall logs an anonymous session in from the remember-me cookie, if the
+// request carries a valid one, and rotates the cookie. An invalid cookie is
+// cleared; a reused one (likely stolen) has revoked its whole series. It
+// reports whether it set a remember-me cookie.
+func recall(manager *sessions.Manager, cfg Config, w http.ResponseWriter, r *http.Request, s *sessions.Session) bool {
+	cookie, err := r.Cookie(cfg.CookiePrefix + sessions.RememberCookieName)
+	if err != nil {
+		return false
+	}
+
+	userID, rotated, err := manager.Recall(cookie.Value)
+	if err == nil {
+		err = s.LoginRemembered(userID)
+	}
+	switch {
+	case errors.Is(err, sessions.ErrRememberTokenReused):
+		manager.Logger().Warn("remember-me token reused, series revoked", "ip", remoteIP(r))
+		fallthrough
+	case errors.Is(err, sessions.ErrRememberTokenInvalid):
+		setCookie(manager, w, expiredRememberCookie(manager, cfg, r))
+	case err != nil:
+		manager.Logger().Error("remember-me login failed", "error", err)
+		if rotated == "" {
+			return false
+		}
+		// The presented token is spent; without its successor the next
+		// request would look like a reuse of a stolen token
+		setCookie(manager, w, newRememberCookie(manager, cfg, r, rotated))
+	case rotated != "":
+		setCookie(manager, w, newRememberCookie(manager, cfg, r, rotated))
+	default:
+		return false
+	}
+	return true
+}
+
+// settleRemember issues a remember-me token when the handler asked for one
+// with Session.Remember, and revokes the presented token when the handler
+// logged the user out. It reports whether it set a remember-me cookie.
+func settleRemember(manager *sessions.Manager, cfg Config, w http.ResponseWriter, r *http.Request, s *sessions.Session, startUser string) bool {
+	if s.RememberRequested() && s.UserID() != "" {
+		value, err := manager.Remember(s.UserID())
+		if err != nil {
+			manager.Logger().Error("remember-me token issue failed", "error", err)
+			return false
+		}
+		setCookie(manager, w, newRememberCookie(manager, cfg, r, value))
+		return true
+	}
+
+	if startUser != "" && s.UserID() == "" {
+		cookie, err := r.Cookie(cfg.CookiePrefix + sessions.RememberCookieName)
+		if err != nil {
+			return false
+		}
+		if err = manager.RevokeRemember(cookie.Value); err != nil {
+			manager.Logger().Error("remember-me token revoke failed", "error", err)
+		}
+		setCookie(manager, w, expiredRememberCookie(manager, cfg, r))
+		return true
+	}
+	return false
+}
+
+func newRememberCookie(manager *sessions.Manager, cfg Config, r *http.Request, value string) *http.Cookie {
+	cookie := newCookie(manager, cfg, r, sessions.RememberCookieName, value, manager.RememberLifetime)
+	cookie.Name = cfg.CookiePrefix + sessions.RememberCookieName
+	return cookie
+}
+
+func expiredRememberCookie(manager *sessions.Manager, cfg Config, r *http.Request) *http.Cookie {
+	cookie := newCookie(manager, cfg, r, sessions.RememberCookieName, "", 0)
+	cookie.Name = cfg.CookiePrefix + sessions.RememberCookieName
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	return cookie
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libtnb/sessions"
+)
+
+func TestRememberRevivesExpiredLogin(t *testing.T) {
+	manager, err := sessions.NewManager(&sessions.ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		Remember:             &sessions.RememberOptions{Driver: newMemoryDriver(false)},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	defer func() { _ = manager.Close() }()
+	if err = manager.Extend("mock", newMemoryDriver(false)); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+
+	var user string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		if err := s.Login("alice"); err != nil {
+			t.Errorf("Login failed: %v", err)
+		}
+		s.Remember()
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		if err := s.Logout(); err != nil {
+			t.Errorf("Logout failed: %v", err)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		user = s.UserID()
+	})
+	handler := StartSession(manager, "mock")(mux)
+
+	cookieNamed := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+	remember := cookieNamed(rr, sessions.RememberCookieName)
+	if remember == nil || remember.MaxAge != manager.RememberLifetime*60 {
+		t.Fatalf("expected a long-lived remember-me cookie, got %+v", remember)
+	}
+
+	// The session cookie is gone (expired); only the remember-me cookie is sent
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(remember)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if user != "alice" {
+		t.Fatalf("remember-me did not revive the login, user=%q", user)
+	}
+	rotated := cookieNamed(rr, sessions.RememberCookieName)
+	if rotated == nil || rotated.Value == remember.Value {
+		t.Fatal("expected the remember-me cookie to be rotated")
+	}
+	session := cookieNamed(rr, sessions.CookieName)
+	if session == nil {
+		t.Fatal("expected a new session cookie")
+	}
+
+	// Logging out revokes the series
+	req = httptest.NewRequest(http.MethodGet, "/logout", nil)
+	req.AddCookie(session)
+	req.AddCookie(rotated)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if c := cookieNamed(rr, sessions.RememberCookieName); c == nil || c.MaxAge >= 0 {
+		t.Fatal("expected the remember-me cookie to be cleared on logout")
+	}
+	if _, _, err = manager.Recall(rotated.Value); err == nil {
+		t.Fatal("remember-me series must be revoked on logout")
+	}
+}
+
+func TestRememberRotationSurvivesPublicResponse(t *testing.T) {
+	manager, err := sessions.NewManager(&sessions.ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		Remember:             &sessions.RememberOptions{Driver: newMemoryDriver(false)},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	defer func() { _ = manager.Close() }()
+	if err = manager.Extend("mock", newMemoryDriver(false)); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+
+	var user string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		if err := s.Login("alice"); err != nil {
+			t.Errorf("Login failed: %v", err)
+		}
+		s.Remember()
+	})
+	mux.HandleFunc("/public", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=600")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		user = s.UserID()
+	})
+	handler := StartSessionWithConfig(manager, Config{Driver: "mock", CacheControl: "private"})(mux)
+
+	rememberCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == sessions.RememberCookieName {
+				return c
+			}
+		}
+		return nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+	remember := rememberCookie(rr)
+	if remember == nil {
+		t.Fatal("expected a remember-me cookie")
+	}
+
+	// The session expired; the first request back hits a public page
+	req := httptest.NewRequest(http.MethodGet, "/public", nil)
+	req.AddCookie(remember)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	rotated := rememberCookie(rr)
+	if rotated == nil || rotated.Value == remember.Value {
+		t.Fatal("the rotated remember-me cookie was stripped from the public response")
+	}
+	if got := rr.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Fatalf("Cache-Control = %q, want the response made private", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(rotated)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if user != "alice" {
+		t.Fatalf("the rotated remember-me cookie did not log in, user=%q", user)
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"net"
 	"net/http"
 )
@@ -11,6 +12,15 @@ import (
 // set headers (the session cookie) after the handler has run. beforeHeader,
 // when set, is invoked once, right before the header is sent to the
 // underlying writer — this is the last moment at which headers can change.
+//
+// In streaming mode nothing is buffered: the header goes out at the first
+// WriteHeader or Write and the body passes straight through. A buffered
+// writer switches to streaming once its body would exceed maxBuffer.
+//
+// Optional interfaces of the wrapped writer stay reachable: io.ReaderFrom,
+// io.StringWriter, http.Pusher, http.Hijacker and the flushers are
+// forwarded directly, and everything else (deadlines, full duplex) through
+// Unwrap and http.ResponseController.
 type responseWriter struct {
 	http.ResponseWriter
 	body         *bytes.Buffer
@@ -19,6 +29,8 @@ type responseWriter struct {
 	headerSent   bool // header was flushed to the underlying writer
 	passthrough  bool
 	hijacked     bool
+	streaming    bool
+	maxBuffer    int // 0 buffers without limit
 	beforeHeader func()
 }
 
@@ -41,32 +53,112 @@ func (w *responseWriter) WriteHeader(code int) {
 	if w.written || w.headerSent {
 		return
 	}
-	w.statusCode = code
-	w.written = true
 
-	if code < http.StatusOK {
-		// For status codes < 200, switch to passthrough mode
+	switch {
+	case code == http.StatusSwitchingProtocols:
+		// The connection leaves HTTP: no session cookie can follow
+		w.statusCode = code
+		w.written = true
 		w.passthrough = true
 		w.ResponseWriter.WriteHeader(code)
+		return
+	case code >= 100 && code < http.StatusOK:
+		// Informational responses (100 Continue, 103 Early Hints) go out
+		// immediately and may be followed by more of them and by the final
+		// status, which is still buffered as usual.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.statusCode = code
+	w.written = true
+	if w.streaming {
+		w.sendHeader()
 	}
 }
 
 func (w *responseWriter) Write(data []byte) (int, error) {
-	if w.passthrough {
+	if w.direct(len(data)) {
+		if err := w.drain(); err != nil {
+			return 0, err
+		}
 		return w.ResponseWriter.Write(data)
 	}
 	return w.body.Write(data)
 }
 
+// WriteString writes s without converting it to a byte slice when the
+// underlying writer supports it.
+func (w *responseWriter) WriteString(s string) (int, error) {
+	if w.direct(len(s)) {
+		if err := w.drain(); err != nil {
+			return 0, err
+		}
+		return io.WriteString(w.ResponseWriter, s)
+	}
+	return w.body.WriteString(s)
+}
+
+// ReadFrom copies r into the response. Once the response streams, the copy
+// is handed to the underlying writer, keeping zero-copy paths such as
+// sendfile for http.ServeContent.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.direct(0) {
+		if err := w.drain(); err != nil {
+			return 0, err
+		}
+		if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+		return io.Copy(writerOnly{w.ResponseWriter}, r)
+	}
+
+	// Buffered: copy through Write, which switches to streaming once the
+	// body outgrows maxBuffer, and hand the remainder over from then on
+	var n int64
+	buf := make([]byte, 32<<10)
+	for {
+		if w.direct(0) {
+			m, err := w.ReadFrom(r)
+			return n + m, err
+		}
+		nr, err := r.Read(buf)
+		if nr > 0 {
+			nw, werr := w.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Push initiates an HTTP/2 server push if the underlying writer supports
+// it.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Hijack takes over the connection. Buffered output is flushed first. It
+// returns http.ErrNotSupported when no writer in the chain is a Hijacker.
 func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if !w.passthrough && w.body.Len() > 0 {
 		w.Flush()
 	}
-	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
 		w.hijacked = true
-		return hijacker.Hijack()
 	}
-	panic("ResponseWriter doesn't implement http.Hijacker")
+	return conn, rw, err
 }
 
 // Flush sends the buffered header and body to the underlying writer. It is
@@ -74,23 +166,67 @@ func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 // out once and the buffer is drained after each flush, so nothing is ever
 // written twice.
 func (w *responseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError is Flush reporting failures, used by http.ResponseController.
+// It returns http.ErrNotSupported when the underlying writer cannot flush;
+// the buffered data has been handed to it regardless.
+func (w *responseWriter) FlushError() error {
 	if w.hijacked {
-		return
+		return nil
 	}
 	if !w.passthrough {
-		if !w.headerSent {
-			if w.beforeHeader != nil {
-				w.beforeHeader()
-			}
-			w.headerSent = true
-			w.ResponseWriter.WriteHeader(w.statusCode)
-		}
-		if w.body.Len() > 0 {
-			_, _ = w.ResponseWriter.Write(w.body.Bytes())
-			w.body.Reset()
+		w.sendHeader()
+		if err := w.drain(); err != nil {
+			return err
 		}
 	}
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// direct reports whether n more bytes go straight to the underlying writer
+// rather than into the buffer, switching to streaming when they would
+// overflow maxBuffer. Once direct, the header has been sent.
+func (w *responseWriter) direct(n int) bool {
+	if w.passthrough {
+		return true
 	}
+	if !w.streaming && w.maxBuffer > 0 && w.body.Len()+n > w.maxBuffer {
+		w.streaming = true
+	}
+	if !w.streaming {
+		return false
+	}
+	w.sendHeader()
+	return true
+}
+
+// drain writes the buffered body to the underlying writer.
+func (w *responseWriter) drain() error {
+	if w.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// sendHeader runs beforeHeader and sends the header to the underlying
+// writer, once.
+func (w *responseWriter) sendHeader() {
+	if w.headerSent {
+		return
+	}
+	if w.beforeHeader != nil {
+		w.beforeHeader()
+	}
+	w.headerSent = true
+	w.ResponseWriter.WriteHeader(w.statusCode)
+}
+
+// writerOnly hides every method but Write, so io.Copy cannot recurse into
+// ReadFrom.
+type writerOnly struct {
+	io.Writer
 }
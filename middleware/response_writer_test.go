@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spyWriter records how the wrapped writer was driven and offers the
+// optional interfaces the middleware must forward.
+type spyWriter struct {
+	*httptest.ResponseRecorder
+	codes      []int
+	readFroms  int
+	pushed     []string
+	deadline   time.Time
+	fullDuplex bool
+}
+
+func newSpyWriter() *spyWriter {
+	return &spyWriter{ResponseRecorder: httptest.NewRecorder()}
+}
+
+func (w *spyWriter) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+	if code >= http.StatusOK {
+		w.ResponseRecorder.WriteHeader(code)
+	}
+}
+
+func (w *spyWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFroms++
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func (w *spyWriter) Push(target string, _ *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func (w *spyWriter) SetReadDeadline(deadline time.Time) error {
+	w.deadline = deadline
+	return nil
+}
+
+func (w *spyWriter) EnableFullDuplex() error {
+	w.fullDuplex = true
+	return nil
+}
+
+func TestResponseWriterReadFromStreamsThroughUnderlying(t *testing.T) {
+	spy := newSpyWriter()
+	w := newResponseWriter(spy)
+	w.streaming = true
+
+	if n, err := w.ReadFrom(strings.NewReader("file contents")); err != nil || n != 13 {
+		t.Fatalf("ReadFrom = %d, %v", n, err)
+	}
+	if spy.readFroms != 1 {
+		t.Fatal("streaming ReadFrom did not reach the underlying io.ReaderFrom")
+	}
+	if spy.Body.String() != "file contents" {
+		t.Fatalf("body = %q", spy.Body.String())
+	}
+}
+
+func TestResponseWriterReadFromBuffersUntilMaxBuffer(t *testing.T) {
+	spy := newSpyWriter()
+	w := newResponseWriter(spy)
+
+	if _, err := w.ReadFrom(strings.NewReader("small")); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if spy.Body.Len() != 0 || spy.readFroms != 0 {
+		t.Fatal("buffered ReadFrom wrote to the underlying writer")
+	}
+
+	w.maxBuffer = 16
+	large := strings.Repeat("x", 64<<10)
+	if n, err := w.ReadFrom(strings.NewReader(large)); err != nil || n != int64(len(large)) {
+		t.Fatalf("ReadFrom = %d, %v", n, err)
+	}
+	if spy.readFroms != 1 {
+		t.Fatal("ReadFrom past MaxBufferSize did not hand over to the underlying io.ReaderFrom")
+	}
+	if spy.Body.String() != "small"+large {
+		t.Fatalf("body has %d bytes, want %d", spy.Body.Len(), 5+len(large))
+	}
+}
+
+func TestResponseWriterWriteString(t *testing.T) {
+	spy := newSpyWriter()
+	w := newResponseWriter(spy)
+
+	if n, err := w.WriteString("buffered"); err != nil || n != 8 {
+		t.Fatalf("WriteString = %d, %v", n, err)
+	}
+	if spy.Body.Len() != 0 {
+		t.Fatal("WriteString bypassed the buffer")
+	}
+	w.Flush()
+	if spy.Body.String() != "buffered" {
+		t.Fatalf("body = %q", spy.Body.String())
+	}
+}
+
+func TestResponseWriterInformationalResponses(t *testing.T) {
+	spy := newSpyWriter()
+	w := newResponseWriter(spy)
+	saved := 0
+	w.beforeHeader = func() { saved++ }
+
+	w.Header().Set("Link", "</style.css>; rel=preload")
+	w.WriteHeader(http.StatusEarlyHints)
+	w.WriteHeader(http.StatusEarlyHints)
+	if saved != 0 {
+		t.Fatal("an informational response triggered the session save")
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte("body"))
+	if spy.Body.Len() != 0 {
+		t.Fatal("the final response is no longer buffered after 103 Early Hints")
+	}
+	w.Flush()
+
+	if want := []int{103, 103, 201}; !slices.Equal(spy.codes, want) {
+		t.Fatalf("status codes sent = %v, want %v", spy.codes, want)
+	}
+	if saved != 1 || spy.Body.String() != "body" {
+		t.Fatalf("saved=%d body=%q", saved, spy.Body.String())
+	}
+}
+
+func TestResponseWriterOptionalInterfaces(t *testing.T) {
+	spy := newSpyWriter()
+	w := newResponseWriter(spy)
+
+	if err := w.Push("/app.js", nil); err != nil || len(spy.pushed) != 1 {
+		t.Fatalf("Push = %v, pushed %v", err, spy.pushed)
+	}
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(time.Minute)
+	if err := rc.SetReadDeadline(deadline); err != nil || !spy.deadline.Equal(deadline) {
+		t.Fatalf("SetReadDeadline = %v, deadline %v", err, spy.deadline)
+	}
+	if err := rc.EnableFullDuplex(); err != nil || !spy.fullDuplex {
+		t.Fatalf("EnableFullDuplex = %v", err)
+	}
+	if err := rc.Flush(); err != nil {
+		t.Fatalf("Flush through ResponseController = %v", err)
+	}
+}
+
+func TestResponseWriterUnsupportedInterfacesReturnErrors(t *testing.T) {
+	// A writer offering nothing but the ResponseWriter methods
+	w := newResponseWriter(struct{ http.ResponseWriter }{httptest.NewRecorder()})
+
+	if _, _, err := w.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Fatalf("Hijack = %v, want http.ErrNotSupported", err)
+	}
+	if err := w.Push("/app.js", nil); !errors.Is(err, http.ErrNotSupported) {
+		t.Fatalf("Push = %v, want http.ErrNotSupported", err)
+	}
+	if err := w.FlushError(); !errors.Is(err, http.ErrNotSupported) {
+		t.Fatalf("FlushError = %v, want http.ErrNotSupported", err)
+	}
+}
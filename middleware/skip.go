@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// botTokens are lower-case user agent fragments of crawlers, link preview
+// fetchers and monitoring tools.
+var botTokens = []string{
+	"bot", "crawl", "spider", "slurp", "facebookexternalhit", "embedly",
+	"preview", "monitor", "pingdom", "uptime", "headless",
+}
+
+// MatchPathPrefix matches requests whose URL path starts with any of the
+// prefixes, e.g. "/static/" or "/healthz".
+func MatchPathPrefix(prefixes ...string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		return slices.ContainsFunc(prefixes, func(prefix string) bool {
+			return strings.HasPrefix(r.URL.Path, prefix)
+		})
+	}
+}
+
+// MatchExtension matches requests whose URL path ends in any of the file
+// extensions, e.g. ".css" or ".png". Matching is case-insensitive.
+func MatchExtension(extensions ...string) func(*http.Request) bool {
+	normalized := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, strings.ToLower(ext))
+	}
+	return func(r *http.Request) bool {
+		ext := path.Ext(r.URL.Path)
+		return ext != "" && slices.Contains(normalized, strings.ToLower(ext))
+	}
+}
+
+// MatchMethod matches requests with any of the methods, e.g.
+// http.MethodOptions for CORS preflights.
+func MatchMethod(methods ...string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		return slices.Contains(methods, r.Method)
+	}
+}
+
+// MatchBot matches requests whose User-Agent looks like a crawler, link
+// preview fetcher or monitoring tool, or is missing. It is a heuristic:
+// clients choose their User-Agent freely.
+func MatchBot() func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		ua := strings.ToLower(r.UserAgent())
+		return ua == "" || slices.ContainsFunc(botTokens, func(token string) bool {
+			return strings.Contains(ua, token)
+		})
+	}
+}
+
+// MatchAny matches requests matched by any of the matchers.
+func MatchAny(matchers ...func(*http.Request) bool) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		return slices.ContainsFunc(matchers, func(match func(*http.Request) bool) bool {
+			return match(r)
+		})
+	}
+}
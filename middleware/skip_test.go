@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	request := func(method, target, ua string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		if ua != "" {
+			r.Header.Set("User-Agent", ua)
+		}
+		return r
+	}
+	browser := "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0"
+
+	tests := []struct {
+		name  string
+		match func(*http.Request) bool
+		r     *http.Request
+		want  bool
+	}{
+		{"prefix", MatchPathPrefix("/static/", "/healthz"), request(http.MethodGet, "/static/app.js", browser), true},
+		{"prefix exact", MatchPathPrefix("/static/", "/healthz"), request(http.MethodGet, "/healthz", browser), true},
+		{"prefix miss", MatchPathPrefix("/static/"), request(http.MethodGet, "/account", browser), false},
+		{"extension", MatchExtension(".css", "png"), request(http.MethodGet, "/img/Logo.PNG", browser), true},
+		{"extension query", MatchExtension(".css"), request(http.MethodGet, "/app.css?v=2", browser), true},
+		{"extension miss", MatchExtension(".css"), request(http.MethodGet, "/css", browser), false},
+		{"method", MatchMethod(http.MethodOptions), request(http.MethodOptions, "/api", browser), true},
+		{"method miss", MatchMethod(http.MethodOptions), request(http.MethodPost, "/api", browser), false},
+		{"bot", MatchBot(), request(http.MethodGet, "/", "Mozilla/5.0 (compatible; Googlebot/2.1)"), true},
+		{"bot empty agent", MatchBot(), request(http.MethodGet, "/", ""), true},
+		{"bot browser", MatchBot(), request(http.MethodGet, "/", browser), false},
+		{"any", MatchAny(MatchMethod(http.MethodHead), MatchPathPrefix("/static/")), request(http.MethodGet, "/static/x", browser), true},
+		{"any miss", MatchAny(MatchMethod(http.MethodHead), MatchPathPrefix("/static/")), request(http.MethodGet, "/", browser), false},
+		{"any empty", MatchAny(), request(http.MethodGet, "/", browser), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match(tt.r); got != tt.want {
+				t.Fatalf("match(%s %s) = %v, want %v", tt.r.Method, tt.r.URL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartSessionSkip(t *testing.T) {
+	driver := newMemoryDriver(false)
+	manager := buildManagerWithDriver(t, driver)
+	handler := StartSessionWithConfig(manager, Config{
+		Driver: "mock",
+		Skip:   MatchAny(MatchPathPrefix("/static/"), MatchMethod(http.MethodOptions)),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, err := manager.GetSession(r); err == nil {
+			s.Put("k", "v")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/static/app.js", nil),
+		httptest.NewRequest(http.MethodOptions, "/api", nil),
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		if rr.Body.String() != "ok" {
+			t.Fatalf("%s %s: body = %q, want ok", r.Method, r.URL, rr.Body.String())
+		}
+		if cookies := rr.Result().Cookies(); len(cookies) != 0 {
+			t.Fatalf("%s %s: got cookies %v, want none", r.Method, r.URL, cookies)
+		}
+	}
+	if len(driver.data) != 0 || len(driver.reads) != 0 {
+		t.Fatalf("skipped requests touched the store: data=%v reads=%v", driver.data, driver.reads)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/account", nil))
+	if len(rr.Result().Cookies()) == 0 || len(driver.data) != 1 {
+		t.Fatal("expected a session for requests that are not skipped")
+	}
+}
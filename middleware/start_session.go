@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"time"
 
@@ -14,8 +15,73 @@ type Config struct {
 	Driver string
 	// Cookie, when set, is called with the prepared session cookie before it
 	// is written, allowing customization of Path, Domain, Secure, SameSite
-	// and the other attributes.
+	// and the other attributes. Renaming the session cookie here also
+	// renames the one read from requests. It is called for the remember-me
+	// cookie too, except that a new name is ignored there; tell the two
+	// apart by cookie.Name.
 	Cookie func(*http.Cookie)
+	// CookiePrefix, HostPrefix or SecurePrefix, is prepended to the session
+	// and remember-me cookie names. Browsers accept such cookies only with
+	// the attributes the prefix demands, so the middleware sets Secure (and
+	// keeps Path=/ without Domain); the Cookie callback must not undo that.
+	CookiePrefix string
+	// Partitioned sets the CHIPS Partitioned attribute, and with it Secure,
+	// so browsers blocking third-party cookies keep the session of an
+	// application embedded in another site's iframe. Such cookies usually
+	// also need SameSite=None, set through the Cookie callback.
+	Partitioned bool
+	// ClientIP, when set, returns the client address recorded in the session
+	// metadata. Defaults to the host part of r.RemoteAddr; behind a reverse
+	// proxy, supply one that reads the header your proxy sets. RateLimit and
+	// ConcurrencyLimit installed inside StartSession use the address it
+	// returns unless configured otherwise.
+	ClientIP func(*http.Request) string
+	// Binding, when set, binds each session to a fingerprint of the client
+	// that created it and applies Binding.Action when another client
+	// presents it.
+	Binding *Binding
+	// Skip, when it returns true, passes the request on without a session:
+	// nothing is read from or written to the store and no cookie is sent,
+	// and GetSession returns sessions.ErrSessionNotFound. Build it from
+	// MatchPathPrefix, MatchExtension, MatchMethod, MatchBot and MatchAny,
+	// e.g. to exempt static assets, health checks and CORS preflights.
+	Skip func(*http.Request) bool
+	// ReadOnly, when it returns true, marks the request's session with
+	// Session.ReadOnly, e.g. MatchMethod(http.MethodGet, http.MethodHead)
+	// for GET-heavy endpoints: handler writes are dropped and logged, and
+	// the session is only touched, never written or locked, except to age
+	// flash data, store an intended URL or record changed metadata, such as
+	// the cookie expiry of CookieRefresh (see Session.ReadOnly). Sessions
+	// the middleware itself had to regenerate (client binding, remember-me
+	// login) stay writable for that request.
+	ReadOnly func(*http.Request) bool
+	// CacheControl, when set (typically "private" or "no-store"), keeps
+	// shared caches from storing a session cookie: responses that carry it
+	// get this Cache-Control directive, unless already private, and Vary:
+	// Cookie. Responses the handler marked as publicly cacheable
+	// (Cache-Control public or s-maxage) get no session cookie instead;
+	// their session is still saved. A response that moves the session to a
+	// new ID (Login, Regenerate), logs the user in from the remember-me
+	// cookie, or sets or clears that cookie, is treated as private
+	// regardless.
+	CacheControl string
+	// CookieRefresh, when positive, stops re-sending the cookie on every
+	// response: a client already holding the current cookie gets it again
+	// only once its expiry would move by at least CookieRefresh. Keep it
+	// well below the manager's Lifetime. The expiry last sent is recorded
+	// in the session metadata.
+	CookieRefresh time.Duration
+	// Streaming saves the session and sets the cookie as soon as the
+	// handler starts the response (its first WriteHeader or Write), then
+	// passes the body straight through instead of buffering it until the
+	// handler returns. This lowers memory use and time to first byte for
+	// large downloads; session changes made after the response started are
+	// still persisted but can no longer affect the cookie.
+	Streaming bool
+	// MaxBufferSize, when positive, caps the buffered body in bytes: a
+	// response growing beyond it switches to streaming for the rest of it.
+	// 0 buffers without limit.
+	MaxBufferSize int
 }
 
 // StartSession is an example middleware that starts a session for each request.
@@ -31,13 +97,31 @@ func StartSession(manager *sessions.Manager, driver ...string) func(next http.Ha
 // StartSessionWithConfig is StartSession with explicit configuration.
 //
 // The session cookie is (re)sent on every response whose session was saved
-// successfully, so its expiry slides along with the server-side lifetime.
+// successfully, so its expiry slides along with the server-side lifetime;
+// Config.CookieRefresh throttles this.
 // For streaming responses the session is saved right before the first byte
 // goes out; changes made after that are still persisted when the handler
 // returns, but can no longer affect the cookie.
+//
+// It panics if cfg fails Validate. A cookie that is only invalid over plain
+// HTTP, e.g. SameSite=None relying on TLS for Secure, is logged and not sent
+// on such requests.
 func StartSessionWithConfig(manager *sessions.Manager, cfg Config) func(next http.Handler) http.Handler {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	sessionCookie := cookieName(cfg, sessions.CookieName)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Skip != nil && cfg.Skip(r) {
+				if cfg.ClientIP != nil {
+					r = withClientIP(r, cfg.ClientIP(r))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Check if session exists
 			if _, ok := r.Context().Value(sessions.CtxKey).(*sessions.Session); ok {
 				next.ServeHTTP(w, r)
@@ -55,13 +139,39 @@ func StartSessionWithConfig(manager *sessions.Manager, cfg Config) func(next htt
 				return
 			}
 
-			// Adopt the session ID from the cookie when present and valid
-			if cookie, err := r.Cookie(s.GetName()); err == nil {
-				s.SetID(cookie.Value)
+			// Adopt the session ID from the cookie when present, correctly
+			// signed and valid
+			var presented string
+			if cookie, err := r.Cookie(sessionCookie); err == nil {
+				presented = cookie.Value
+				if id, ok := manager.VerifyID(cookie.Value); ok {
+					s.SetID(id)
+				}
 			}
 
-			// Start session
+			// Start session and record who is using it
 			s.Start()
+			startID := s.GetID()
+			ip := clientIPFunc(cfg.ClientIP)(r)
+			s.SetClient(ip, r.UserAgent())
+			if cfg.Binding != nil {
+				if err = cfg.Binding.apply(r, s, ip); err != nil {
+					manager.ReleaseSession(s)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+
+			// Revive an expired login from the remember-me cookie
+			remembered := false
+			if manager.RememberLifetime > 0 && s.UserID() == "" {
+				remembered = recall(manager, cfg, w, r, s)
+			}
+			startUser := s.UserID()
+			if cfg.ReadOnly != nil && s.GetID() == startID && cfg.ReadOnly(r) {
+				s.ReadOnly()
+			}
+			r = withClientIP(r, ip)
 			r = r.WithContext(context.WithValue(r.Context(), sessions.CtxKey, s)) //nolint:staticcheck
 
 			// saveAndSetCookie persists the session and, on success, (re)sends
@@ -75,36 +185,56 @@ func StartSessionWithConfig(manager *sessions.Manager, cfg Config) func(next htt
 				}
 				saved = true
 
+				if manager.RememberLifetime > 0 && settleRemember(manager, cfg, w, r, s, startUser) {
+					remembered = true
+				}
+
+				// A response carrying a remember-me cookie, or moving the
+				// session to a new ID (Login, Regenerate), is never public:
+				// the old token or ID is already spent, so the new cookie must
+				// reach the client, and only this client.
+				private := remembered || s.GetID() != startID
+				public := cfg.CacheControl != "" && !private && publiclyCacheable(w.Header())
+				if public {
+					removeSetCookie(w.Header(), sessionCookie)
+				} else if private && cfg.CacheControl != "" {
+					guardCache(w.Header(), cfg.CacheControl)
+				}
+				expiresAt := manager.Clock().Now().Add(time.Duration(manager.Lifetime) * time.Minute)
+				issue := !public && !s.IsNoSlide() && cookieStale(manager, cfg, s, presented, expiresAt)
+				if issue && cfg.CookieRefresh > 0 {
+					s.SetCookieExpiry(expiresAt)
+				}
+
 				if err := s.Save(); err != nil {
 					manager.Logger().Error("session save failed", "error", err)
 					return
 				}
-
-				cookie := &http.Cookie{
-					Name:     s.GetName(),
-					Value:    s.GetID(),
-					MaxAge:   manager.Lifetime * 60,
-					Expires:  time.Now().Add(time.Duration(manager.Lifetime) * time.Minute),
-					Path:     "/",
-					HttpOnly: true,
-					Secure:   r.TLS != nil,
-					SameSite: http.SameSiteLaxMode,
+				if !issue || !s.IsPersisted() {
+					// Public response, NoSlide, cookie still fresh, or nothing
+					// stored under PersistWhenNeeded
+					return
 				}
-				if cfg.Cookie != nil {
-					cfg.Cookie(cookie)
+
+				cookie := newCookie(manager, cfg, r, s.GetName(), manager.SignID(s.GetID()), manager.Lifetime)
+				cookie.Name = sessionCookie
+				setCookie(manager, w, cookie)
+				if cfg.CacheControl != "" {
+					guardCache(w.Header(), cfg.CacheControl)
 				}
-				http.SetCookie(w, cookie)
 			}
 
 			// Continue processing request
 			writer := newResponseWriter(w)
+			writer.streaming = cfg.Streaming
+			writer.maxBuffer = cfg.MaxBufferSize
 			writer.beforeHeader = saveAndSetCookie
 			next.ServeHTTP(writer, r)
 
 			// Save session and set the cookie (no-op if a streaming flush
 			// already did it)
 			saveAndSetCookie()
-			if writer.headerSent && s.IsDirty() {
+			if writer.headerSent && s.IsDirty() && !s.IsReadOnly() {
 				// The handler modified the session after the header went out
 				// (e.g. during a streaming response): persist the late
 				// changes; the cookie for this response is already fixed.
@@ -119,3 +249,36 @@ func StartSessionWithConfig(manager *sessions.Manager, cfg Config) func(next htt
 		})
 	}
 }
+
+// clientIPKey is the request context key of the client address
+// StartSession resolved.
+type clientIPKey struct{}
+
+// withClientIP returns r carrying ip as the resolved client address.
+func withClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// clientIPFunc returns how a middleware determines the client address:
+// with fn when set, otherwise as StartSession resolved it for the request
+// (with Config.ClientIP), or else from r.RemoteAddr.
+func clientIPFunc(fn func(*http.Request) string) func(*http.Request) string {
+	if fn != nil {
+		return fn
+	}
+	return func(r *http.Request) string {
+		if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+			return ip
+		}
+		return remoteIP(r)
+	}
+}
+
+// remoteIP returns the host part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -14,12 +14,14 @@ type memoryDriver struct {
 	mu        sync.Mutex
 	data      map[string]string
 	failWrite bool
+	reads     map[string]int
 }
 
 func newMemoryDriver(failWrite bool) *memoryDriver {
 	return &memoryDriver{
 		data:      make(map[string]string),
 		failWrite: failWrite,
+		reads:     make(map[string]int),
 	}
 }
 
@@ -50,6 +52,7 @@ func (d *memoryDriver) Touch(id string) (bool, error) {
 func (d *memoryDriver) Read(id string) (string, bool, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	d.reads[id]++
 	value, ok := d.data[id]
 	if !ok {
 		return "", false, nil
@@ -149,6 +152,56 @@ func TestStartSessionStreamingDoesNotDuplicateBody(t *testing.T) {
 	}
 }
 
+func TestStartSessionStreamingModePassesWritesThrough(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	rr := httptest.NewRecorder()
+	handler := StartSessionWithConfig(manager, Config{Driver: "mock", Streaming: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		s.Put("k", "v")
+
+		w.WriteHeader(http.StatusAccepted)
+		if rr.Code != http.StatusAccepted {
+			t.Error("WriteHeader did not reach the client in streaming mode")
+		}
+		if len(rr.Result().Cookies()) == 0 {
+			t.Error("session cookie not set at WriteHeader in streaming mode")
+		}
+		_, _ = w.Write([]byte("chunk"))
+		if rr.Body.String() != "chunk" {
+			t.Errorf("body = %q before the handler returned, want it passed through", rr.Body.String())
+		}
+	}))
+
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "chunk" {
+		t.Fatalf("response = %d %q, want 202 \"chunk\"", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStartSessionMaxBufferSizeSwitchesToStreaming(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	rr := httptest.NewRecorder()
+	handler := StartSessionWithConfig(manager, Config{Driver: "mock", MaxBufferSize: 8})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("small"))
+		if rr.Body.Len() != 0 {
+			t.Error("a body within MaxBufferSize was not buffered")
+		}
+		_, _ = w.Write([]byte(" and then large"))
+		if rr.Body.String() != "small and then large" {
+			t.Errorf("body = %q after exceeding MaxBufferSize, want it flushed", rr.Body.String())
+		}
+		if len(rr.Result().Cookies()) == 0 {
+			t.Error("session cookie not set before the body went out")
+		}
+		_, _ = w.Write([]byte("!"))
+	}))
+
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rr.Body.String(); got != "small and then large!" {
+		t.Fatalf("body = %q", got)
+	}
+}
+
 func TestStartSessionPersistsChangesMadeAfterStreamingStarted(t *testing.T) {
 	driver := newMemoryDriver(false)
 	manager := buildManagerWithDriver(t, driver)
@@ -365,3 +418,162 @@ func TestStartSessionDoesNotReissueCookieForDestroyedSession(t *testing.T) {
 		t.Fatal("destroyed session was resurrected in the store")
 	}
 }
+
+func TestStartSessionRecordsClientMeta(t *testing.T) {
+	manager := buildManagerWithDriver(t, newMemoryDriver(false))
+	var meta sessions.Meta
+	handler := StartSessionWithConfig(manager, Config{
+		Driver: "mock",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		meta = s.Meta()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("User-Agent", "test-agent")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if meta.IP != "192.0.2.10" || meta.UserAgent != "test-agent" {
+		t.Fatalf("client meta not recorded: %+v", meta)
+	}
+	if meta.CreatedAt.IsZero() {
+		t.Fatal("CreatedAt not set on a new session")
+	}
+}
+
+func TestStartSessionSignedIDsRejectForgedCookies(t *testing.T) {
+	d := newMemoryDriver(false)
+	manager, err := sessions.NewManager(&sessions.ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		SignIDs:              true,
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	defer func() { _ = manager.Close() }()
+	if err = manager.Extend("mock", d); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+
+	var seen string
+	handler := StartSession(manager, "mock")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		seen = s.GetID()
+		s.Put("k", "v")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookie := rr.Result().Cookies()[0]
+	if cookie.Value == seen {
+		t.Fatal("cookie carries the bare session ID, want it signed")
+	}
+	id := seen
+
+	// The signed cookie resumes the session
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != id {
+		t.Fatalf("signed cookie resumed %q, want %q", seen, id)
+	}
+
+	// A bare or tampered ID never reaches the store
+	random := "tvaJLmGkYOfA8vTitC57tducl6BU10jd"
+	for _, forged := range []string{id, random, random + cookie.Value[32:], cookie.Value + "x"} {
+		reads := d.reads[id] + d.reads[random]
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessions.CookieName, Value: forged})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if seen == id {
+			t.Fatalf("forged cookie %q resumed the session", forged)
+		}
+		if d.reads[id]+d.reads[random] != reads {
+			t.Fatalf("forged cookie %q caused a store lookup", forged)
+		}
+	}
+}
+
+func TestStartSessionPersistWhenNeeded(t *testing.T) {
+	driver := newMemoryDriver(false)
+	manager, err := sessions.NewManager(&sessions.ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		Persist:              sessions.PersistWhenNeeded,
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if err = manager.Extend("mock", driver); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	handler := StartSession(manager, "mock")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		if r.URL.Path == "/cart" {
+			s.Put("cart", 1)
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if cookies := rr.Result().Cookies(); len(cookies) != 0 || len(driver.data) != 0 {
+		t.Fatalf("anonymous visit: cookies=%v stored=%d, want neither", cookies, len(driver.data))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if len(rr.Result().Cookies()) != 1 || len(driver.data) != 1 {
+		t.Fatal("expected the session to be stored and its cookie sent once it holds data")
+	}
+}
+
+func TestStartSessionReadOnly(t *testing.T) {
+	driver := newMemoryDriver(false)
+	manager := buildManagerWithDriver(t, driver)
+	handler := StartSessionWithConfig(manager, Config{
+		Driver:   "mock",
+		ReadOnly: MatchMethod(http.MethodGet),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := manager.GetSession(r)
+		if err != nil {
+			t.Errorf("GetSession failed: %v", err)
+			return
+		}
+		if r.URL.Query().Has("write") {
+			s.Put("k", r.Method)
+		}
+	}))
+
+	// A new visitor on a read-only route gets no stored session
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/?write", nil))
+	if len(rr.Result().Cookies()) != 0 || len(driver.data) != 0 {
+		t.Fatal("read-only request stored a session")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/?write", nil))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("expected a cookie from the writable request")
+	}
+	id := cookies[0].Value
+	stored := driver.data[id]
+
+	req := httptest.NewRequest(http.MethodGet, "/?write", nil)
+	req.AddCookie(cookies[0])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if driver.data[id] != stored {
+		t.Fatal("read-only request modified the stored session")
+	}
+}
@@ -0,0 +1,50 @@
+package sessions
+
+// PersistPolicy selects when Save stores a session that is not in the store
+// yet.
+type PersistPolicy int
+
+const (
+	// PersistAlways stores every saved session, even an empty one, so a
+	// visitor keeps the same session ID across requests.
+	PersistAlways PersistPolicy = iota
+	// PersistWhenNeeded stores a new session only once it holds attributes
+	// (including flash data), belongs to an authenticated user or was marked
+	// with Session.Persist. Until then Save writes nothing and the
+	// middleware sends no cookie, so crawlers and one-off visitors leave no
+	// trace in the store. Sessions that are already stored are saved as
+	// usual, even once they are emptied.
+	PersistWhenNeeded
+)
+
+// Persist marks the session for storage under PersistWhenNeeded even
+// though it holds no data, e.g. to start tracking a visitor who accepted a
+// consent banner. It has no effect under PersistAlways.
+func (s *Session) Persist() *Session {
+	if !s.persist && s.writable() {
+		s.persist = true
+		s.dirty = true
+	}
+	return s
+}
+
+// IsPersisted reports whether the session is in the store: it was loaded at
+// Start or written by Save. Under PersistWhenNeeded it stays false after
+// Save for a session that holds nothing worth storing.
+func (s *Session) IsPersisted() bool {
+	return s.persisted
+}
+
+// deferPersist reports whether Save should skip storing the session under
+// PersistWhenNeeded. A session whose data was flushed or whose ID was
+// regenerated is always stored, as the client may still hold an ID that
+// must be replaced.
+func (s *Session) deferPersist() bool {
+	if s.manager == nil || s.manager.persist != PersistWhenNeeded {
+		return false
+	}
+	if s.persisted || s.loaded || s.flushed || s.persist {
+		return false
+	}
+	return len(s.attributes) == 0 && s.meta.UserID == ""
+}
@@ -0,0 +1,88 @@
+package sessions
+
+import "testing"
+
+func TestPersistWhenNeeded(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	manager.persist = PersistWhenNeeded
+
+	save := func(fn func(s *Session)) *Session {
+		t.Helper()
+		s, err := manager.BuildSession(CookieName, "mock")
+		if err != nil {
+			t.Fatalf("BuildSession failed: %v", err)
+		}
+		t.Cleanup(func() { manager.ReleaseSession(s) })
+		s.Start()
+		s.SetClient("192.0.2.1", "crawler")
+		fn(s)
+		if err = s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return s
+	}
+
+	empty := save(func(*Session) {})
+	if empty.IsPersisted() || d.writes != 0 {
+		t.Fatalf("empty session: persisted=%v writes=%d, want nothing stored", empty.IsPersisted(), d.writes)
+	}
+
+	cases := map[string]func(s *Session){
+		"attribute": func(s *Session) { s.Put("cart", 1) },
+		"flash":     func(s *Session) { s.Flash("status", "saved") },
+		"user":      func(s *Session) { _ = s.SetUserID("42") },
+		"marker":    func(s *Session) { s.Persist() },
+	}
+	for name, fn := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := save(fn)
+			d.mu.Lock()
+			_, stored := d.data[s.GetID()]
+			d.mu.Unlock()
+			if !s.IsPersisted() || !stored {
+				t.Fatalf("persisted=%v stored=%v, want the session stored", s.IsPersisted(), stored)
+			}
+		})
+	}
+}
+
+func TestPersistWhenNeededKeepsSavingStoredSessions(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	manager.persist = PersistWhenNeeded
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s.Start()
+	s.Put("cart", 1)
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+
+	// Emptying a stored session must be saved, not skipped
+	s, err = manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.SetID(id)
+	s.Start()
+	s.Forget("cart")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if !s.IsPersisted() {
+		t.Fatal("stored session reported as not persisted")
+	}
+
+	s.SetID(id)
+	s.Start()
+	if s.GetID() != id || s.Exists("cart") {
+		t.Fatalf("got id=%s cart=%v, want the emptied session %s", s.GetID(), s.Get("cart"), id)
+	}
+}
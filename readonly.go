@@ -0,0 +1,177 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+
+	"github.com/libtnb/sessions/driver"
+)
+
+// ErrSessionReadOnly reports a write to a session marked with ReadOnly. The
+// write is dropped and Save returns this error; with ManagerOptions.Debug
+// the write panics with it instead.
+var ErrSessionReadOnly = errors.New("session is read-only")
+
+// maxTrackedTouches bounds the manager's record of read-only touches; past
+// it, the least recently touched sessions are forgotten, at worst costing
+// them an early touch.
+const maxTrackedTouches = 4096
+
+// touchRecord is when the manager last touched a read-only session.
+type touchRecord struct {
+	id string
+	at time.Time
+}
+
+// ReadOnly marks the session as read-only for the rest of the request, for
+// endpoints that must not write to it. Writes (Put, Forget, Flash, Flush,
+// Regenerate, Login, ...) are dropped. Save does not take the per-session
+// lock: it only refreshes the store timestamp with Touch, throttled by
+// TouchInterval, and never stores a session that is not in the store yet.
+// Three kinds of bookkeeping are exempt: flash data shown by the request is
+// aged, SetIntendedURL and IntendedURL work, and metadata changes of a
+// stored session (SetClient, SetFingerprint, SetCookieExpiry) are kept, so
+// Save stores those changes, merged like any other save.
+func (s *Session) ReadOnly() *Session {
+	s.readOnly = true
+	return s
+}
+
+// Peek marks the session for a look that must leave it exactly as stored,
+// e.g. to report how long it has left. It implies ReadOnly and NoSlide, and
+// Save stores nothing at all: flash data is not aged, intended URL and
+// metadata changes are dropped, and the session is not touched.
+func (s *Session) Peek() *Session {
+	s.peek = true
+	return s.ReadOnly().NoSlide()
+}
+
+// IsReadOnly reports whether ReadOnly was called.
+func (s *Session) IsReadOnly() bool {
+	return s.readOnly
+}
+
+// writable reports whether the session may be modified, recording a write
+// to a read-only session for Save to report, or panicking in debug mode.
+func (s *Session) writable() bool {
+	if !s.readOnly {
+		return true
+	}
+	s.readOnlyWrite = true
+	if s.manager != nil && s.manager.debug {
+		panic(ErrSessionReadOnly)
+	}
+	return false
+}
+
+// exempt runs fn with the session writable, recording that a read-only
+// session now holds a change Save must store.
+func (s *Session) exempt(fn func()) {
+	if !s.readOnly {
+		fn()
+		return
+	}
+	s.readOnly = false
+	defer func() { s.readOnly = true }()
+	fn()
+	s.exemptWrite = s.exemptWrite || s.dirty
+}
+
+// hasFlashData reports whether flash data is due to be aged.
+func (s *Session) hasFlashData() bool {
+	return len(s.flashKeys(flashOldKey)) > 0 || len(s.flashKeys(flashNewKey)) > 0
+}
+
+// saveReadOnly is Save for a read-only session.
+func (s *Session) saveReadOnly() error {
+	var err error
+	// A peek stores nothing. Otherwise dropped writes leave the session
+	// clean: it is dirty with changes made before ReadOnly or by the
+	// metadata setters, which always apply
+	if s.peek {
+		s.started = false
+	} else if s.exemptWrite || s.loaded && (s.dirty || s.hasFlashData()) {
+		s.readOnly = false
+		err = s.Save()
+		s.readOnly = true
+	} else {
+		s.started = false
+		if s.loaded && (s.touch || !s.noSlide && !s.touchedRecently()) {
+			err = s.touchReadOnly()
+		}
+	}
+	if err == nil && s.readOnlyWrite {
+		err = ErrSessionReadOnly
+	}
+	return err
+}
+
+// touchedRecently reports whether the store timestamp of the loaded session
+// was refreshed within the manager's TouchInterval. Meta.LastActivity only
+// advances on writes, so the driver's activity (driver.ActivityReporter) or
+// the manager's record of its own touches decides.
+func (s *Session) touchedRecently() bool {
+	interval := s.touchInterval()
+	if interval <= 0 {
+		return false
+	}
+	last := s.meta.LastActivity
+	if reporter, ok := s.driver.(driver.ActivityReporter); ok {
+		if at, found, err := reporter.LastActivity(s.GetID()); err == nil && found && at.After(last) {
+			last = at
+		}
+	} else if s.manager != nil {
+		if at, ok := s.manager.lastTouch(s.GetID()); ok && at.After(last) {
+			last = at
+		}
+	}
+	return s.now().Sub(last) < interval
+}
+
+// touchReadOnly refreshes the store timestamp of the read-only session.
+func (s *Session) touchReadOnly() error {
+	found, err := s.driver.Touch(s.GetID())
+	if err == nil {
+		s.touch = false
+	}
+	if err != nil || !found || s.manager == nil || s.touchInterval() <= 0 {
+		return err
+	}
+	if _, ok := s.driver.(driver.ActivityReporter); !ok {
+		s.manager.recordTouch(s.GetID(), s.now(), s.touchInterval())
+	}
+	return nil
+}
+
+// lastTouch returns when the manager last touched the read-only session id.
+func (m *Manager) lastTouch(id string) (time.Time, bool) {
+	m.touchesMu.Lock()
+	defer m.touchesMu.Unlock()
+	elem, ok := m.touches[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	return elem.Value.(*touchRecord).at, true
+}
+
+// recordTouch records a touch of the read-only session id. Records are kept
+// in touch order, so those too old to throttle anything, and the oldest
+// beyond maxTrackedTouches, are dropped from the front.
+func (m *Manager) recordTouch(id string, at time.Time, interval time.Duration) {
+	m.touchesMu.Lock()
+	defer m.touchesMu.Unlock()
+	if elem, ok := m.touches[id]; ok {
+		elem.Value.(*touchRecord).at = at
+		m.touchOrder.MoveToBack(elem)
+	} else {
+		m.touches[id] = m.touchOrder.PushBack(&touchRecord{id: id, at: at})
+	}
+	for elem := m.touchOrder.Front(); elem != nil; elem = m.touchOrder.Front() {
+		oldest := elem.Value.(*touchRecord)
+		if m.touchOrder.Len() <= maxTrackedTouches && at.Sub(oldest.at) < interval {
+			break
+		}
+		m.touchOrder.Remove(elem)
+		delete(m.touches, oldest.id)
+	}
+}
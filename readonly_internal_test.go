@@ -0,0 +1,241 @@
+package sessions
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+)
+
+// stepClock is a clock.Clock that only moves when told to.
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) NewTicker(d time.Duration) clock.Ticker {
+	return clock.System.NewTicker(d)
+}
+
+func (c *stepClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// readOnlyRequest runs fn on the stored session id as a read-only request
+// and saves it.
+func readOnlyRequest(t *testing.T, manager *Manager, id string, fn func(s *Session)) {
+	t.Helper()
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.SetID(id)
+	s.Start()
+	s.ReadOnly()
+	if fn != nil {
+		fn(s)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("read-only Save failed: %v", err)
+	}
+}
+
+func TestSessionReadOnly(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s.Start()
+	s.Put("cart", 1).Put("status", "saved")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+	writes := d.writes
+
+	s, err = manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.SetID(id)
+	s.Start()
+	s.ReadOnly()
+
+	// Reading and a clean Save work and only touch the session
+	if err = s.Save(); err != nil {
+		t.Fatalf("read-only Save = %v, want nil", err)
+	}
+	if d.writes != writes || d.touches != 1 {
+		t.Fatalf("writes=%d touches=%d, want no write and one touch", d.writes-writes, d.touches)
+	}
+
+	s.Put("cart", 2)
+	s.Forget("status")
+	if err = s.Regenerate(); !errors.Is(err, ErrSessionReadOnly) {
+		t.Fatalf("Regenerate = %v, want ErrSessionReadOnly", err)
+	}
+	if err = s.Login("42"); !errors.Is(err, ErrSessionReadOnly) {
+		t.Fatalf("Login = %v, want ErrSessionReadOnly", err)
+	}
+	if s.Get("cart") != 1 || !s.Exists("status") || s.GetID() != id || s.UserID() != "" {
+		t.Fatal("write to a read-only session was applied")
+	}
+	if err = s.Save(); !errors.Is(err, ErrSessionReadOnly) {
+		t.Fatalf("Save after dropped writes = %v, want ErrSessionReadOnly", err)
+	}
+	if d.writes != writes {
+		t.Fatal("read-only Save wrote to the store")
+	}
+}
+
+func TestSessionReadOnlyPanicsInDebugMode(t *testing.T) {
+	manager := testManagerWithDriver(t, newMemoryDriver())
+	manager.debug = true
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.Start()
+	s.ReadOnly()
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrSessionReadOnly) {
+			t.Fatalf("recovered %v, want ErrSessionReadOnly", err)
+		}
+	}()
+	s.Put("k", "v")
+}
+
+func TestSessionReadOnlyTouchIsThrottled(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	clk := &stepClock{now: time.Now()}
+	manager.clock = clk
+	manager.TouchInterval = 5
+
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.Start()
+	s.Put("cart", 1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+
+	// The last write is long past, but the touches themselves throttle
+	for _, step := range []struct {
+		advance time.Duration
+		touches int
+	}{
+		{10 * time.Minute, 1},
+		{time.Minute, 1},
+		{time.Minute, 1},
+		{4 * time.Minute, 2},
+	} {
+		clk.advance(step.advance)
+		readOnlyRequest(t, manager, id, nil)
+		if d.touches != step.touches {
+			t.Fatalf("touches = %d, want %d", d.touches, step.touches)
+		}
+	}
+}
+
+func TestSessionReadOnlyAgesFlashData(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	manager.debug = true
+
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.Start()
+	s.Flash("status", "saved")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+
+	readOnlyRequest(t, manager, id, func(s *Session) {
+		if s.Get("status") != "saved" {
+			t.Fatal("flash data missing on the request after it was set")
+		}
+	})
+	readOnlyRequest(t, manager, id, func(s *Session) {
+		if s.Exists("status") {
+			t.Fatal("flash data survived the read-only request that showed it")
+		}
+	})
+}
+
+func TestSessionReadOnlyIntendedURL(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	manager.debug = true
+
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.Start()
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+
+	readOnlyRequest(t, manager, id, func(s *Session) { s.SetIntendedURL("/account") })
+	readOnlyRequest(t, manager, id, func(s *Session) {
+		if got := s.IntendedURL("/"); got != "/account" {
+			t.Fatalf("IntendedURL = %q, want /account", got)
+		}
+	})
+	writes := d.writes
+	readOnlyRequest(t, manager, id, func(s *Session) {
+		if got := s.IntendedURL("/"); got != "/" {
+			t.Fatalf("IntendedURL after it was read = %q, want the fallback", got)
+		}
+	})
+	if d.writes != writes {
+		t.Fatal("IntendedURL without a stored URL wrote the read-only session")
+	}
+}
+
+func TestManagerTouchRecordIsBounded(t *testing.T) {
+	manager := testManagerWithDriver(t, newMemoryDriver())
+	now := time.Now()
+
+	// Every record is recent enough to throttle, yet the oldest go
+	for i := range maxTrackedTouches + 10 {
+		manager.recordTouch(strconv.Itoa(i), now.Add(time.Duration(i)), time.Hour)
+	}
+	if n := len(manager.touches); n != maxTrackedTouches || manager.touchOrder.Len() != n {
+		t.Fatalf("touch record holds %d entries (%d ordered), want %d", n, manager.touchOrder.Len(), maxTrackedTouches)
+	}
+	if _, ok := manager.lastTouch("0"); ok {
+		t.Fatal("the least recently touched session was kept")
+	}
+	if _, ok := manager.lastTouch(strconv.Itoa(maxTrackedTouches + 9)); !ok {
+		t.Fatal("the latest touch was dropped")
+	}
+
+	// Records too old to throttle anything are dropped as well
+	manager.recordTouch("late", now.Add(2*time.Hour), time.Hour)
+	if n := len(manager.touches); n != 1 {
+		t.Fatalf("touch record holds %d entries after an hour, want 1", n)
+	}
+}
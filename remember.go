@@ -0,0 +1,186 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/libtnb/securecookie"
+
+	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/sessionid"
+)
+
+// RememberCookieName is the default remember-me cookie name.
+var RememberCookieName = "remember"
+
+// DefaultRememberLifetime is used when RememberOptions.Lifetime is not
+// positive.
+const DefaultRememberLifetime = 30 * 24 * 60 // minutes
+
+// rememberGrace is how long the previous validator of a series stays valid
+// after a rotation, so parallel requests sent with the old cookie (several
+// tabs restoring at once) are not mistaken for a stolen token.
+const rememberGrace = 30 * time.Second
+
+// rememberCodecName binds stored tokens to their purpose; it is fixed so
+// renaming the cookie does not invalidate existing series.
+const rememberCodecName = "remember"
+
+// Sentinel errors returned by the remember-me subsystem; test with errors.Is.
+var (
+	ErrRememberDisabled     = errors.New("remember-me is not enabled")
+	ErrRememberTokenInvalid = errors.New("remember-me token invalid or expired")
+	// ErrRememberTokenReused reports a validator that was already rotated
+	// away: the token was most likely stolen and replayed, so the whole
+	// series has been revoked.
+	ErrRememberTokenReused = errors.New("remember-me token reused, series revoked")
+)
+
+// RememberOptions enables long-lived remember-me tokens, which revive an
+// authenticated session after the session itself expired.
+type RememberOptions struct {
+	// Driver stores the hashed tokens. It must be dedicated to remember-me
+	// tokens and keep entries for at least Lifetime, e.g.
+	// driver.NewFile(dir, sessions.DefaultRememberLifetime).
+	Driver driver.Driver
+	// Lifetime is the token lifetime in minutes, extended on every use.
+	// Defaults to DefaultRememberLifetime.
+	Lifetime int
+}
+
+// rememberToken is the stored form of a remember-me series. The series is
+// keyed by its selector; the validator is only ever stored hashed.
+type rememberToken struct {
+	UserID    string
+	Hash      []byte
+	PrevHash  []byte // validator hash before the latest rotation
+	RotatedAt time.Time
+}
+
+type rememberStore struct {
+	driver driver.Driver
+	codec  securecookie.Codec
+}
+
+func newRememberStore(key []byte, option *RememberOptions, lifetime int) (*rememberStore, error) {
+	if option.Driver == nil {
+		return nil, ErrDriverNotSet
+	}
+	codec, err := securecookie.New(key, &securecookie.Options{
+		MaxAge:     int64(lifetime) * 60,
+		Serializer: securecookie.GobEncoder{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rememberStore{driver: option.Driver, codec: codec}, nil
+}
+
+// Remember starts a new remember-me series for userID and returns the
+// cookie value ("<selector>:<validator>") to send to the client.
+func (m *Manager) Remember(userID string) (string, error) {
+	if m.remember == nil {
+		return "", ErrRememberDisabled
+	}
+	selector := sessionid.Default.New()
+	validator, hash := newValidator()
+	if err := m.writeRememberToken(selector, &rememberToken{UserID: userID, Hash: hash}); err != nil {
+		return "", err
+	}
+	return selector + ":" + validator, nil
+}
+
+// Recall validates a remember-me cookie value and returns its user. The
+// validator is rotated on every use; rotated is the new cookie value, or ""
+// when the client presented the previous validator within the grace period
+// of a rotation (a parallel request will deliver the new one). A validator
+// that was rotated away longer ago revokes the series and returns
+// ErrRememberTokenReused.
+func (m *Manager) Recall(value string) (userID, rotated string, err error) {
+	if m.remember == nil {
+		return "", "", ErrRememberDisabled
+	}
+	selector, validator, ok := strings.Cut(value, ":")
+	if !ok || !sessionid.Default.Valid(selector) {
+		return "", "", ErrRememberTokenInvalid
+	}
+
+	m.LockSession(selector)
+	defer m.UnlockSession(selector)
+
+	token, err := m.readRememberToken(selector)
+	if err != nil {
+		return "", "", err
+	}
+	if token == nil {
+		return "", "", ErrRememberTokenInvalid
+	}
+
+	hash := hashValidator(validator)
+	switch {
+	case subtle.ConstantTimeCompare(hash, token.Hash) == 1:
+		newValue, newHash := newValidator()
+		token.PrevHash, token.Hash, token.RotatedAt = token.Hash, newHash, m.clock.Now()
+		if err = m.writeRememberToken(selector, token); err != nil {
+			return "", "", err
+		}
+		return token.UserID, selector + ":" + newValue, nil
+	case subtle.ConstantTimeCompare(hash, token.PrevHash) == 1 && m.clock.Now().Sub(token.RotatedAt) < rememberGrace:
+		return token.UserID, "", nil
+	default:
+		if err = m.remember.driver.Destroy(selector); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRememberTokenReused
+	}
+}
+
+// RevokeRemember deletes the remember-me series of a cookie value, e.g. on
+// logout. Unknown or malformed values are ignored.
+func (m *Manager) RevokeRemember(value string) error {
+	if m.remember == nil {
+		return ErrRememberDisabled
+	}
+	selector, _, ok := strings.Cut(value, ":")
+	if !ok || !sessionid.Default.Valid(selector) {
+		return nil
+	}
+	m.LockSession(selector)
+	defer m.UnlockSession(selector)
+	return m.remember.driver.Destroy(selector)
+}
+
+func (m *Manager) readRememberToken(selector string) (*rememberToken, error) {
+	data, found, err := m.remember.driver.Read(selector)
+	if err != nil || !found {
+		return nil, err
+	}
+	var token rememberToken
+	if _, err = m.remember.codec.Decode(rememberCodecName, data, &token); err != nil {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (m *Manager) writeRememberToken(selector string, token *rememberToken) error {
+	data, err := m.remember.codec.Encode(rememberCodecName, token)
+	if err != nil {
+		return err
+	}
+	return m.remember.driver.Write(selector, data)
+}
+
+// newValidator returns a random validator and its hash.
+func newValidator() (string, []byte) {
+	validator := rand.Text()
+	return validator, hashValidator(validator)
+}
+
+func hashValidator(validator string) []byte {
+	sum := sha256.Sum256([]byte(validator))
+	return sum[:]
+}
@@ -0,0 +1,103 @@
+package sessions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testManagerWithRemember(t *testing.T, tokens *memoryDriver) *Manager {
+	t.Helper()
+
+	m, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		Remember:             &RememberOptions{Driver: tokens},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func TestRememberRotatesOnEveryUse(t *testing.T) {
+	manager := testManagerWithRemember(t, newMemoryDriver())
+
+	value, err := manager.Remember("alice")
+	if err != nil {
+		t.Fatalf("Remember failed: %v", err)
+	}
+	userID, rotated, err := manager.Recall(value)
+	if err != nil || userID != "alice" {
+		t.Fatalf("Recall = %q, %v; want alice", userID, err)
+	}
+	if rotated == "" || rotated == value {
+		t.Fatal("expected the validator to be rotated")
+	}
+	if userID, _, err = manager.Recall(rotated); err != nil || userID != "alice" {
+		t.Fatalf("Recall of the rotated token = %q, %v; want alice", userID, err)
+	}
+}
+
+func TestRememberGraceForParallelRequests(t *testing.T) {
+	manager := testManagerWithRemember(t, newMemoryDriver())
+
+	value, _ := manager.Remember("alice")
+	if _, _, err := manager.Recall(value); err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+
+	// A second tab restoring with the same, just rotated, cookie
+	userID, rotated, err := manager.Recall(value)
+	if err != nil || userID != "alice" {
+		t.Fatalf("Recall within grace = %q, %v; want alice", userID, err)
+	}
+	if rotated != "" {
+		t.Fatal("a request within the grace period must not rotate again")
+	}
+}
+
+func TestRememberReuseRevokesSeries(t *testing.T) {
+	tokens := newMemoryDriver()
+	manager := testManagerWithRemember(t, tokens)
+
+	stolen, _ := manager.Remember("alice")
+	_, current, err := manager.Recall(stolen)
+	if err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+
+	// Age the rotation past the grace period, then replay the old validator
+	selector, _, _ := strings.Cut(stolen, ":")
+	token, err := manager.readRememberToken(selector)
+	if err != nil || token == nil {
+		t.Fatalf("readRememberToken = %v, %v", token, err)
+	}
+	token.RotatedAt = time.Now().Add(-time.Hour)
+	if err = manager.writeRememberToken(selector, token); err != nil {
+		t.Fatalf("writeRememberToken failed: %v", err)
+	}
+
+	if _, _, err = manager.Recall(stolen); !errors.Is(err, ErrRememberTokenReused) {
+		t.Fatalf("Recall of a reused validator = %v, want ErrRememberTokenReused", err)
+	}
+	if _, _, err = manager.Recall(current); !errors.Is(err, ErrRememberTokenInvalid) {
+		t.Fatalf("Recall after revocation = %v, want ErrRememberTokenInvalid", err)
+	}
+}
+
+func TestRememberRejectsMalformedAndDisabled(t *testing.T) {
+	manager := testManagerWithRemember(t, newMemoryDriver())
+	for _, value := range []string{"", "no-colon", "../../etc/passwd:x", "12345678901234567890123456789012:x"} {
+		if _, _, err := manager.Recall(value); !errors.Is(err, ErrRememberTokenInvalid) {
+			t.Errorf("Recall(%q) = %v, want ErrRememberTokenInvalid", value, err)
+		}
+	}
+
+	disabled := testManagerWithDriver(t, newMemoryDriver())
+	if _, err := disabled.Remember("alice"); !errors.Is(err, ErrRememberDisabled) {
+		t.Fatalf("Remember without options = %v, want ErrRememberDisabled", err)
+	}
+}
@@ -4,19 +4,18 @@ import (
 	"errors"
 	stdmaps "maps"
 	"slices"
-
-	"github.com/jaevor/go-nanoid"
+	"time"
 
 	"github.com/libtnb/securecookie"
 	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/sessionid"
 )
 
 const (
-	sessionIDAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	sessionIDLength   = 32
-
 	flashNewKey = "_flash.new"
 	flashOldKey = "_flash.old"
+
+	intendedURLKey = "_auth.intended"
 )
 
 // ErrSessionDestroyed reports that a session which existed when the request
@@ -25,25 +24,33 @@ const (
 // refused: recreating the session would resurrect an invalidated ID.
 var ErrSessionDestroyed = errors.New("session destroyed concurrently")
 
-// newSessionID is shared by all sessions; the generator is mutex-guarded
-// internally, so creating it once avoids per-request initialization cost.
-var newSessionID = nanoid.MustCustomASCII(sessionIDAlphabet, sessionIDLength)
-
 // Session holds the data of a single session for the duration of a request.
 // It is not safe for concurrent use by multiple goroutines.
 type Session struct {
-	id         string
-	name       string
-	attributes map[string]any
-	codec      securecookie.Codec
-	driver     driver.Driver
-	manager    *Manager // used to serialize Save calls per session ID
-	started    bool
-	dirty      bool
-	loaded     bool            // session data was loaded from the store at Start
-	flushed    bool            // Flush or Regenerate was called; Save skips merging
-	puts       map[string]any  // keys put during this request
-	forgets    map[string]bool // keys forgotten during this request
+	id            string
+	name          string
+	attributes    map[string]any
+	codec         securecookie.Codec
+	driver        driver.Driver
+	manager       *Manager // used to serialize Save calls per session ID
+	started       bool
+	dirty         bool
+	loaded        bool // session data was loaded from the store at Start
+	meta          Meta
+	baseMeta      Meta            // meta as loaded at Start; Save merges changes against it
+	flushed       bool            // Flush or Regenerate was called; Save skips merging
+	remember      bool            // Remember was called; the middleware issues a token
+	persist       bool            // Persist was called; stored under PersistWhenNeeded
+	persisted     bool            // the session is in the store under its current ID
+	readOnly      bool            // ReadOnly was called; writes are dropped
+	readOnlyWrite bool            // a write to the read-only session was dropped
+	exemptWrite   bool            // a read-only session holds a change Save must store
+	noSlide       bool            // NoSlide was called; unchanged sessions are not refreshed
+	peek          bool            // Peek was called; Save stores and touches nothing
+	touch         bool            // Touch was called; Save refreshes the store timestamp
+	indexPending  bool            // the session must be added to its user index on Save
+	puts          map[string]any  // keys put during this request
+	forgets       map[string]bool // keys forgotten during this request
 }
 
 // All returns a copy of the session attributes. Mutating the returned map
@@ -52,6 +59,47 @@ func (s *Session) All() map[string]any {
 	return stdmaps.Clone(s.attributes)
 }
 
+// AuthenticatedAt returns when the user logged in, or the zero time for an
+// anonymous session.
+func (s *Session) AuthenticatedAt() time.Time {
+	return s.meta.AuthenticatedAt
+}
+
+// ConfirmPassword records that the user just re-entered their password (or
+// otherwise re-authenticated), for sensitive actions guarded by
+// RecentlyConfirmed. Call it only after verifying the credentials.
+func (s *Session) ConfirmPassword() *Session {
+	if !s.writable() {
+		return s
+	}
+	s.meta.PasswordConfirmedAt = s.now()
+	s.dirty = true
+	return s
+}
+
+// Discard abandons the loaded session without touching the store: the
+// attributes and all metadata, authentication included, are cleared and a
+// new, never-stored ID is generated, so Save stores a brand-new session
+// while the previous one stays intact for its owner.
+func (s *Session) Discard() *Session {
+	if !s.writable() {
+		return s
+	}
+	s.attributes = make(map[string]any)
+	s.puts = make(map[string]any)
+	s.forgets = make(map[string]bool)
+	s.meta = Meta{CreatedAt: s.now()}
+	s.baseMeta = Meta{}
+	s.id = s.generateSessionID()
+	s.loaded = false
+	s.persisted = false
+	s.flushed = true // nothing to merge with
+	s.remember = false
+	s.indexPending = false
+	s.dirty = true
+	return s
+}
+
 // Exists reports whether the key is present, even if its value is nil.
 func (s *Session) Exists(key string) bool {
 	_, ok := s.attributes[key]
@@ -69,6 +117,9 @@ func (s *Session) Flash(key string, value any) *Session {
 
 // Flush removes all attributes from the session.
 func (s *Session) Flush() *Session {
+	if !s.writable() {
+		return s
+	}
 	s.attributes = make(map[string]any)
 	s.puts = make(map[string]any)
 	s.forgets = make(map[string]bool)
@@ -79,6 +130,9 @@ func (s *Session) Flush() *Session {
 
 // Forget removes the given keys from the session.
 func (s *Session) Forget(keys ...string) *Session {
+	if !s.writable() {
+		return s
+	}
 	for _, key := range keys {
 		delete(s.attributes, key)
 		s.forgets[key] = true
@@ -100,6 +154,63 @@ func (s *Session) Get(key string, defaultValue ...any) any {
 	return nil
 }
 
+// Meta returns the session metadata.
+func (s *Session) Meta() Meta {
+	return s.meta
+}
+
+// SetClient records the client address and user agent of the current
+// request in the session metadata. The session is only marked dirty when
+// either value changed.
+func (s *Session) SetClient(ip, userAgent string) *Session {
+	if s.meta.IP == ip && s.meta.UserAgent == userAgent {
+		return s
+	}
+	s.meta.IP = ip
+	s.meta.UserAgent = userAgent
+	s.dirty = true
+	return s
+}
+
+// SetFingerprint binds the session to a client fingerprint, recorded in the
+// session metadata. The session is only marked dirty when it changed.
+func (s *Session) SetFingerprint(fingerprint string) *Session {
+	if s.meta.Fingerprint == fingerprint {
+		return s
+	}
+	s.meta.Fingerprint = fingerprint
+	s.dirty = true
+	return s
+}
+
+// SetUserID marks the session as belonging to the authenticated user, or
+// as anonymous for an empty userID. When ManagerOptions.MaxSessionsPerUser
+// is set, the cap is enforced here: depending on the policy the user's
+// oldest sessions are destroyed, or ErrSessionLimitReached is returned and
+// the session is left unchanged.
+func (s *Session) SetUserID(userID string) error {
+	if s.meta.UserID == userID {
+		return nil
+	}
+	if !s.writable() {
+		return ErrSessionReadOnly
+	}
+	if userID != "" && s.manager != nil {
+		if err := s.manager.enforceSessionLimit(s, userID); err != nil {
+			return err
+		}
+	}
+	s.meta.UserID = userID
+	s.indexPending = userID != ""
+	s.dirty = true
+	return nil
+}
+
+// UserID returns the authenticated user, or "" for an anonymous session.
+func (s *Session) UserID() string {
+	return s.meta.UserID
+}
+
 // GetID returns the session ID.
 func (s *Session) GetID() string {
 	return s.id
@@ -120,10 +231,32 @@ func (s *Session) Has(key string) bool {
 	return val != nil
 }
 
-// Invalidate flushes all attributes and regenerates the session ID,
-// destroying the previously stored session.
+// IntendedURL returns and forgets the URL stored with SetIntendedURL, or
+// fallback when there is none. Like SetIntendedURL, it works on read-only
+// sessions.
+func (s *Session) IntendedURL(fallback string) string {
+	var url string
+	s.exempt(func() {
+		if s.Exists(intendedURLKey) {
+			url, _ = s.Pull(intendedURLKey).(string)
+		}
+	})
+	if url != "" {
+		return url
+	}
+	return fallback
+}
+
+// Invalidate flushes all attributes, logs the user out and regenerates the
+// session ID, destroying the previously stored session.
 func (s *Session) Invalidate() error {
+	if !s.writable() {
+		return ErrSessionReadOnly
+	}
 	s.Flush()
+	s.meta.UserID = ""
+	s.meta.AuthenticatedAt = time.Time{}
+	s.meta.PasswordConfirmedAt = time.Time{}
 	return s.migrate(true)
 }
 
@@ -139,6 +272,27 @@ func (s *Session) Keep(keys ...string) *Session {
 	return s
 }
 
+// Login authenticates the session as userID. The session data moves to a
+// new ID and the old one is destroyed, so an ID planted in the browser
+// before login (session fixation) is worthless afterwards. The per-user
+// session cap is enforced first; when it rejects the login the session is
+// left unchanged.
+func (s *Session) Login(userID string) error {
+	return s.login(userID, true)
+}
+
+// LoginRemembered authenticates the session as userID on the strength of a
+// remember-me token. It is Login, except that it does not count as a
+// password confirmation for RecentlyConfirmed.
+func (s *Session) LoginRemembered(userID string) error {
+	return s.login(userID, false)
+}
+
+// Logout logs the user out by invalidating the session.
+func (s *Session) Logout() error {
+	return s.Invalidate()
+}
+
 // Missing reports whether the key is absent or nil.
 func (s *Session) Missing(key string) bool {
 	return !s.Has(key)
@@ -178,6 +332,9 @@ func (s *Session) Pull(key string, def ...any) any {
 
 // Put stores a key/value pair in the session.
 func (s *Session) Put(key string, value any) *Session {
+	if !s.writable() {
+		return s
+	}
 	s.attributes[key] = value
 	s.puts[key] = value
 	delete(s.forgets, key)
@@ -185,6 +342,12 @@ func (s *Session) Put(key string, value any) *Session {
 	return s
 }
 
+// RecentlyConfirmed reports whether the user logged in or confirmed their
+// password within maxAge. Anonymous sessions are never confirmed.
+func (s *Session) RecentlyConfirmed(maxAge time.Duration) bool {
+	return s.meta.UserID != "" && s.now().Sub(s.meta.PasswordConfirmedAt) <= maxAge
+}
+
 // Reflash extends all current flash data for one more request.
 func (s *Session) Reflash() *Session {
 	s.mergeNewFlashes(s.flashKeys(flashOldKey)...)
@@ -198,6 +361,22 @@ func (s *Session) Regenerate(destroy ...bool) error {
 	return s.migrate(destroy...)
 }
 
+// Remember asks the middleware to issue a remember-me token for the
+// authenticated user along with the response, typically right after Login
+// when the user ticked "remember me". It requires ManagerOptions.Remember.
+func (s *Session) Remember() *Session {
+	if !s.writable() {
+		return s
+	}
+	s.remember = true
+	return s
+}
+
+// RememberRequested reports whether Remember was called.
+func (s *Session) RememberRequested() bool {
+	return s.remember
+}
+
 // Remove removes the key from the session and returns its previous value.
 func (s *Session) Remove(key string) any {
 	return s.Pull(key)
@@ -208,15 +387,35 @@ func (s *Session) Remove(key string) any {
 // keys written or forgotten during this request are applied on top of the
 // latest stored state.
 func (s *Session) Save() error {
+	if s.readOnly {
+		return s.saveReadOnly()
+	}
 	s.ageFlashData()
 
+	if !s.dirty && !s.touch && (s.noSlide || s.recentlyWritten()) {
+		// NoSlide, or refreshed within TouchInterval: skip the store
+		// round-trip.
+		s.started = false
+		return nil
+	}
+
+	if s.deferPersist() {
+		// Nothing worth storing yet under PersistWhenNeeded.
+		s.started = false
+		return nil
+	}
+
 	// Hold the per-session lock only while reading and writing the store.
 	if s.manager != nil {
 		s.manager.LockSession(s.GetID())
 		defer s.manager.UnlockSession(s.GetID())
 	}
 
-	if !s.dirty {
+	// With a TouchInterval the recorded activity (Meta.LastActivity) only
+	// advances on writes, so an overdue refresh rewrites the session
+	// (falling through to the merge below) instead of touching it, unless
+	// Touch asked for exactly that.
+	if !s.dirty && (s.touchInterval() == 0 || s.touch) {
 		// No changes: refresh the store timestamp so GC keeps the active
 		// session alive.
 		found, err := s.driver.Touch(s.GetID())
@@ -226,7 +425,10 @@ func (s *Session) Save() error {
 			return err
 		}
 		if found {
+			s.persisted = true
 			s.started = false
+			s.touch = false
+			s.track()
 			return nil
 		}
 		if s.loaded {
@@ -238,11 +440,11 @@ func (s *Session) Save() error {
 		// the session ID stays stable across requests.
 	}
 
-	var final map[string]any
+	var final record
 
 	if s.flushed {
 		// Flush or Regenerate was called; use the current state as-is.
-		final = s.attributes
+		final = record{Attributes: s.attributes, Meta: s.meta}
 	} else {
 		// Merge this request's changes on top of the latest stored state.
 		latest, err := s.readFromHandler()
@@ -256,16 +458,25 @@ func (s *Session) Save() error {
 				// Destroyed concurrently since Start; refuse to resurrect it.
 				return ErrSessionDestroyed
 			}
-			latest = make(map[string]any)
+			latest = &record{Attributes: make(map[string]any), Meta: s.baseMeta}
 		}
 		for key := range s.forgets {
-			delete(latest, key)
+			delete(latest.Attributes, key)
 		}
-		stdmaps.Copy(latest, s.puts)
-		final = latest
+		stdmaps.Copy(latest.Attributes, s.puts)
+		final = record{
+			Attributes: latest.Attributes,
+			Meta:       mergeMeta(s.baseMeta, s.meta, latest.Meta),
+		}
+	}
+
+	now := s.now()
+	if final.Meta.CreatedAt.IsZero() {
+		final.Meta.CreatedAt = now
 	}
+	final.Meta.LastActivity = now
 
-	data, err := s.codec.Encode(s.GetName(), final)
+	data, err := s.codec.Encode(s.GetName(), &final)
 	if err != nil {
 		return err
 	}
@@ -274,13 +485,40 @@ func (s *Session) Save() error {
 		return err
 	}
 
+	s.meta = final.Meta
+	s.baseMeta = final.Meta
+	s.persisted = true
 	s.dirty = false
 	s.started = false
+	s.track()
 	return nil
 }
 
-// SetID sets the session ID. Invalid IDs (wrong length or characters outside
-// [0-9A-Za-z]) are replaced with a newly generated one.
+// SetCookieExpiry records when the session cookie just sent to the client
+// expires. The session is only marked dirty when it changed. Like the other
+// metadata setters, it works on read-only sessions too.
+func (s *Session) SetCookieExpiry(expiresAt time.Time) *Session {
+	if s.meta.CookieExpiresAt.Equal(expiresAt) {
+		return s
+	}
+	s.meta.CookieExpiresAt = expiresAt
+	s.dirty = true
+	return s
+}
+
+// SetIntendedURL remembers the URL a guard redirected away from, so the
+// login or confirmation handler can send the user back with IntendedURL.
+// It works on read-only sessions too, as guards redirect GET requests,
+// the ones usually marked read-only.
+func (s *Session) SetIntendedURL(url string) *Session {
+	s.exempt(func() {
+		s.Put(intendedURLKey, url)
+	})
+	return s
+}
+
+// SetID sets the session ID. IDs the manager's IDGenerator rejects are
+// replaced with a newly generated one.
 func (s *Session) SetID(id string) *Session {
 	if s.isValidID(id) {
 		s.id = id
@@ -288,6 +526,7 @@ func (s *Session) SetID(id string) *Session {
 		s.id = s.generateSessionID()
 	}
 	s.loaded = false
+	s.persisted = false
 
 	return s
 }
@@ -303,6 +542,7 @@ func (s *Session) SetName(name string) *Session {
 func (s *Session) Start() bool {
 	if !s.loadSession() {
 		s.id = s.generateSessionID()
+		s.meta.CreatedAt = s.now()
 	}
 	s.started = true
 	return s.started
@@ -314,37 +554,93 @@ func (s *Session) IsStarted() bool {
 }
 
 func (s *Session) generateSessionID() string {
-	return newSessionID()
+	return s.idGenerator().New()
 }
 
 func (s *Session) isValidID(id string) bool {
-	if len(id) != sessionIDLength {
-		return false
-	}
-	for i := 0; i < len(id); i++ {
-		c := id[i]
-		if (c < '0' || c > '9') &&
-			(c < 'A' || c > 'Z') &&
-			(c < 'a' || c > 'z') {
-			return false
-		}
+	return s.idGenerator().Valid(id)
+}
+
+// idGenerator returns the manager's session ID generator.
+func (s *Session) idGenerator() sessionid.Generator {
+	if s.manager == nil {
+		return sessionid.Default
 	}
-	return true
+	return s.manager.ids
 }
 
 func (s *Session) loadSession() bool {
 	// A store failure degrades to a fresh session here; Save's merge path
 	// re-checks the store and refuses to overwrite data it cannot read.
-	data, _ := s.readFromHandler()
-	if data == nil {
+	rec, _ := s.readFromHandler()
+	if rec == nil {
 		return false
 	}
-	stdmaps.Copy(s.attributes, data)
+	stdmaps.Copy(s.attributes, rec.Attributes)
+	s.meta = rec.Meta
+	s.baseMeta = rec.Meta
 	s.loaded = true
+	s.persisted = true
 	return true
 }
 
+// track keeps the user index of the stored session current.
+func (s *Session) track() {
+	if s.manager != nil {
+		s.manager.trackSession(s)
+	}
+}
+
+func (s *Session) touchInterval() time.Duration {
+	if s.manager == nil {
+		return 0
+	}
+	return time.Duration(s.manager.TouchInterval) * time.Minute
+}
+
+// now returns the current time from the manager's clock.
+func (s *Session) now() time.Time {
+	if s.manager == nil {
+		return time.Now()
+	}
+	return s.manager.clock.Now()
+}
+
+// recentlyWritten reports whether the loaded session was written within the
+// manager's TouchInterval, so refreshing its store timestamp can be skipped.
+func (s *Session) recentlyWritten() bool {
+	interval := s.touchInterval()
+	return interval > 0 && s.loaded && s.now().Sub(s.meta.LastActivity) < interval
+}
+
+func (s *Session) login(userID string, confirmed bool) error {
+	if !s.writable() {
+		return ErrSessionReadOnly
+	}
+	if userID != "" && userID != s.meta.UserID && s.manager != nil {
+		if err := s.manager.enforceSessionLimit(s, userID); err != nil {
+			return err
+		}
+	}
+	// The user is only recorded once the session moved to its new ID, so a
+	// failed migration never leaves the old, possibly planted ID authenticated.
+	if err := s.migrate(true); err != nil {
+		return err
+	}
+	now := s.now()
+	s.meta.UserID = userID
+	s.meta.AuthenticatedAt = now
+	if confirmed {
+		s.meta.PasswordConfirmedAt = now
+	}
+	s.indexPending = userID != ""
+	return nil
+}
+
 func (s *Session) migrate(destroy ...bool) error {
+	if !s.writable() {
+		return ErrSessionReadOnly
+	}
 	shouldDestroy := false
 	if len(destroy) > 0 {
 		shouldDestroy = destroy[0]
@@ -359,15 +655,17 @@ func (s *Session) migrate(destroy ...bool) error {
 	s.id = s.generateSessionID()
 	s.dirty = true
 	s.loaded = false // the new ID has never been persisted
+	s.persisted = false
+	s.indexPending = s.meta.UserID != ""
 	s.flushed = true // new session ID, nothing to merge with
 	return nil
 }
 
-// readFromHandler returns the stored session data. A missing session or
+// readFromHandler returns the stored session record. A missing session or
 // undecodable payload (corrupt data, rotated key) yields (nil, nil) — both
 // mean "start fresh". A store failure is returned as an error so callers
 // never mistake an outage for an empty session.
-func (s *Session) readFromHandler() (map[string]any, error) {
+func (s *Session) readFromHandler() (*record, error) {
 	value, found, err := s.driver.Read(s.GetID())
 	if err != nil {
 		return nil, err
@@ -375,12 +673,7 @@ func (s *Session) readFromHandler() (map[string]any, error) {
 	if !found {
 		return nil, nil
 	}
-
-	var data map[string]any
-	if _, err = s.codec.Decode(s.GetName(), value, &data); err != nil {
-		return nil, nil
-	}
-	return data, nil
+	return decodeRecord(s.codec, s.GetName(), value), nil
 }
 
 func (s *Session) ageFlashData() {
@@ -464,6 +757,18 @@ func (s *Session) reset() {
 	s.started = false
 	s.dirty = false
 	s.loaded = false
+	s.remember = false
+	s.persist = false
+	s.persisted = false
+	s.readOnly = false
+	s.readOnlyWrite = false
+	s.exemptWrite = false
+	s.noSlide = false
+	s.peek = false
+	s.touch = false
+	s.indexPending = false
+	s.meta = Meta{}
+	s.baseMeta = Meta{}
 	s.flushed = false
 }
 
@@ -1,25 +1,33 @@
 package sessions
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/libtnb/sessions/clock"
 	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/sessionid"
 )
 
 type memoryDriver struct {
-	mu        sync.Mutex
-	data      map[string]string
-	writes    int
-	touches   int
-	closes    int
-	failWrite bool
-	failTouch bool // Touch returns a non-not-found error
-	failRead  bool // Read returns a non-not-found error
+	mu          sync.Mutex
+	data        map[string]string
+	writes      int
+	touches     int
+	lists       int
+	closes      int
+	failWrite   bool
+	failTouch   bool // Touch returns a non-not-found error
+	failRead    bool // Read returns a non-not-found error
+	failDestroy bool
 }
 
 func newMemoryDriver() *memoryDriver {
@@ -38,6 +46,9 @@ func (d *memoryDriver) Close() error {
 func (d *memoryDriver) Destroy(id string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if d.failDestroy {
+		return errors.New("destroy failed")
+	}
 	delete(d.data, id)
 	return nil
 }
@@ -72,6 +83,19 @@ func (d *memoryDriver) Read(id string) (string, bool, error) {
 	return value, true, nil
 }
 
+func (d *memoryDriver) List(fn func(id string, data string) bool) error {
+	d.mu.Lock()
+	d.lists++
+	snapshot := maps.Clone(d.data)
+	d.mu.Unlock()
+	for id, data := range snapshot {
+		if !fn(id, data) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (d *memoryDriver) Write(id string, data string) error {
 	if d.failWrite {
 		return fmt.Errorf("write failed")
@@ -216,6 +240,344 @@ func TestSessionSaveTouchesWhenNotDirty(t *testing.T) {
 	manager.ReleaseSession(s3)
 }
 
+func TestSessionSaveThrottlesTouchWithinInterval(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+	manager.TouchInterval = 5
+
+	s1, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s1.Start()
+	s1.Put("key", "value")
+	if err = s1.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	sessionID := s1.GetID()
+	manager.ReleaseSession(s1)
+
+	counts := func() (int, int) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.writes, d.touches
+	}
+	writesBefore, touchesBefore := counts()
+
+	// Unchanged and written moments ago: no store round-trip at all
+	s2, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s2.SetID(sessionID)
+	s2.Start()
+	if err = s2.Save(); err != nil {
+		t.Fatalf("Save (throttled) failed: %v", err)
+	}
+	manager.ReleaseSession(s2)
+
+	if writes, touches := counts(); writes != writesBefore || touches != touchesBefore {
+		t.Fatalf("throttled save hit the store: writes %d -> %d, touches %d -> %d",
+			writesBefore, writes, touchesBefore, touches)
+	}
+
+	// Unchanged but overdue: rewritten so the recorded activity advances
+	s3, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s3.SetID(sessionID)
+	s3.Start()
+	s3.meta.LastActivity = time.Now().Add(-10 * time.Minute)
+	if err = s3.Save(); err != nil {
+		t.Fatalf("Save (overdue) failed: %v", err)
+	}
+	manager.ReleaseSession(s3)
+
+	if writes, touches := counts(); writes != writesBefore+1 || touches != touchesBefore {
+		t.Fatalf("overdue save: writes %d -> %d, touches %d -> %d, want one rewrite",
+			writesBefore, writes, touchesBefore, touches)
+	}
+
+	s4, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s4.SetID(sessionID)
+	s4.Start()
+	if got := s4.Get("key"); got != "value" {
+		t.Fatalf("expected 'value' after overdue rewrite, got %v", got)
+	}
+	manager.ReleaseSession(s4)
+}
+
+func TestSessionMetaIsPersistedAndMergedPerField(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	seed, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	seed.Start()
+	seed.SetClient("192.0.2.1", "agent/1")
+	if err = seed.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := seed.GetID()
+	created := seed.Meta().CreatedAt
+	manager.ReleaseSession(seed)
+
+	// Two overlapping requests: A moves to a new address, B only writes a key
+	// and saves last. B must not roll back A's metadata.
+	a, _ := manager.BuildSession(CookieName, "mock")
+	a.SetID(id)
+	a.Start()
+	b, _ := manager.BuildSession(CookieName, "mock")
+	b.SetID(id)
+	b.Start()
+
+	a.SetClient("198.51.100.7", "agent/1")
+	if err = a.Save(); err != nil {
+		t.Fatalf("A Save failed: %v", err)
+	}
+	manager.ReleaseSession(a)
+	b.Put("key", "value")
+	if err = b.Save(); err != nil {
+		t.Fatalf("B Save failed: %v", err)
+	}
+	manager.ReleaseSession(b)
+
+	s, _ := manager.BuildSession(CookieName, "mock")
+	defer manager.ReleaseSession(s)
+	s.SetID(id)
+	s.Start()
+	meta := s.Meta()
+	if meta.IP != "198.51.100.7" || meta.UserAgent != "agent/1" {
+		t.Fatalf("client info not merged: %+v", meta)
+	}
+	if !meta.CreatedAt.Equal(created) {
+		t.Fatalf("CreatedAt changed: %v -> %v", created, meta.CreatedAt)
+	}
+	if meta.LastActivity.IsZero() {
+		t.Fatal("LastActivity not recorded")
+	}
+	if s.Get("key") != "value" {
+		t.Fatal("attribute lost while merging metadata")
+	}
+	if s.Exists("IP") || len(s.All()) != 1 {
+		t.Fatalf("metadata leaked into attributes: %v", s.All())
+	}
+}
+
+func TestSessionStartUpgradesLegacyPayload(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	// Sessions stored before metadata existed hold a bare attribute map
+	id := sessionid.Default.New()
+	legacy, err := manager.Codec.Encode(CookieName, map[string]any{"cart": "3", "theme": "dark"})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	d.data[id] = legacy
+
+	s, _ := manager.BuildSession(CookieName, "mock")
+	s.SetID(id)
+	s.Start()
+	if s.GetID() != id || !s.IsPersisted() {
+		t.Fatal("legacy session was not loaded")
+	}
+	if s.Get("cart") != "3" || s.Get("theme") != "dark" || len(s.All()) != 2 {
+		t.Fatalf("legacy attributes = %v", s.All())
+	}
+	if meta := s.Meta(); meta.LastActivity.IsZero() || meta.UserID != "" || !meta.CreatedAt.IsZero() {
+		t.Fatalf("legacy meta = %+v, want only LastActivity from the codec timestamp", meta)
+	}
+
+	// The next save stores it in the current format, attributes intact
+	s.Put("cart", "4")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	manager.ReleaseSession(s)
+	var rec record
+	if _, err = manager.Codec.Decode(CookieName, d.data[id], &rec); err != nil {
+		t.Fatalf("upgraded session is not a record: %v", err)
+	}
+	if rec.Attributes["cart"] != "4" || rec.Attributes["theme"] != "dark" || rec.Meta.CreatedAt.IsZero() {
+		t.Fatalf("upgraded record = %+v", rec)
+	}
+}
+
+func TestManagerSessionsListsMeta(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	want := make(map[string]string)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		s, err := manager.BuildSession(CookieName, "mock")
+		if err != nil {
+			t.Fatalf("BuildSession failed: %v", err)
+		}
+		s.Start()
+		s.SetClient(ip, "agent")
+		if err = s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		want[s.GetID()] = ip
+		manager.ReleaseSession(s)
+	}
+	d.mu.Lock()
+	d.data["corrupt"] = "not a session"
+	d.mu.Unlock()
+
+	got := make(map[string]string)
+	err := manager.Sessions(CookieName, func(id string, meta Meta) bool {
+		got[id] = meta.IP
+		return true
+	}, "mock")
+	if err != nil {
+		t.Fatalf("Sessions failed: %v", err)
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("Sessions = %v, want %v", got, want)
+	}
+}
+
+func TestSessionLoginPreventsFixationAndLogoutClearsUser(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	// An anonymous session whose ID an attacker may have planted
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s.Start()
+	s.Put("cart", "book")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	plantedID := s.GetID()
+	manager.ReleaseSession(s)
+
+	s, _ = manager.BuildSession(CookieName, "mock")
+	s.SetID(plantedID)
+	s.Start()
+	if err = s.Login("alice"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if s.GetID() == plantedID {
+		t.Fatal("Login must regenerate the session ID")
+	}
+	if s.UserID() != "alice" || s.AuthenticatedAt().IsZero() {
+		t.Fatalf("Login did not record the user: %q at %v", s.UserID(), s.AuthenticatedAt())
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loggedInID := s.GetID()
+	manager.ReleaseSession(s)
+
+	d.mu.Lock()
+	_, plantedExists := d.data[plantedID]
+	d.mu.Unlock()
+	if plantedExists {
+		t.Fatal("Login must destroy the pre-login session")
+	}
+
+	s, _ = manager.BuildSession(CookieName, "mock")
+	s.SetID(loggedInID)
+	s.Start()
+	if s.UserID() != "alice" || s.Get("cart") != "book" {
+		t.Fatalf("logged-in session not persisted: user=%q cart=%v", s.UserID(), s.Get("cart"))
+	}
+	if err = s.Logout(); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if s.UserID() != "" || !s.AuthenticatedAt().IsZero() || s.Exists("cart") {
+		t.Fatal("Logout must clear the user and the session data")
+	}
+	if s.GetID() == loggedInID {
+		t.Fatal("Logout must regenerate the session ID")
+	}
+	manager.ReleaseSession(s)
+}
+
+func TestSessionLoginFailureLeavesSessionAnonymous(t *testing.T) {
+	d := newMemoryDriver()
+	manager := testManagerWithDriver(t, d)
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	defer manager.ReleaseSession(s)
+	s.Start()
+	plantedID := s.GetID()
+
+	d.failDestroy = true
+	if err = s.Login("alice"); err == nil {
+		t.Fatal("Login must fail when the old session cannot be destroyed")
+	}
+	if s.GetID() != plantedID {
+		t.Fatal("a failed Login must keep the session ID")
+	}
+	if s.UserID() != "" || !s.AuthenticatedAt().IsZero() || s.RecentlyConfirmed(time.Hour) {
+		t.Fatalf("a failed Login authenticated the old ID as %q", s.UserID())
+	}
+}
+
+func TestSessionPasswordConfirmationSurvivesMerge(t *testing.T) {
+	manager := testManagerWithDriver(t, newMemoryDriver())
+
+	s, err := manager.BuildSession(CookieName, "mock")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s.Start()
+	if err = s.Login("alice"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	s.meta.PasswordConfirmedAt = time.Now().Add(-time.Hour)
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	id := s.GetID()
+	manager.ReleaseSession(s)
+
+	// A confirms the password while B, loaded before, saves last
+	a, _ := manager.BuildSession(CookieName, "mock")
+	a.SetID(id)
+	a.Start()
+	b, _ := manager.BuildSession(CookieName, "mock")
+	b.SetID(id)
+	b.Start()
+	if a.RecentlyConfirmed(5 * time.Minute) {
+		t.Fatal("stale confirmation must not count as recent")
+	}
+
+	a.ConfirmPassword()
+	if err = a.Save(); err != nil {
+		t.Fatalf("A Save failed: %v", err)
+	}
+	manager.ReleaseSession(a)
+	b.Put("key", "value")
+	if err = b.Save(); err != nil {
+		t.Fatalf("B Save failed: %v", err)
+	}
+	manager.ReleaseSession(b)
+
+	s, _ = manager.BuildSession(CookieName, "mock")
+	defer manager.ReleaseSession(s)
+	s.SetID(id)
+	s.Start()
+	if !s.RecentlyConfirmed(5 * time.Minute) {
+		t.Fatal("password confirmation was rolled back by a concurrent save")
+	}
+}
+
 func TestSessionRejectsUnsafeID(t *testing.T) {
 	manager := testManagerWithDriver(t, newMemoryDriver())
 	session, err := manager.BuildSession(CookieName, "mock")
@@ -233,6 +595,53 @@ func TestSessionRejectsUnsafeID(t *testing.T) {
 	}
 }
 
+func TestSessionUsesManagerIDGenerator(t *testing.T) {
+	ids := sessionid.Prefixed("eu1-", sessionid.UUIDv7())
+	manager, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		IDGenerator:          ids,
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	defer func() { _ = manager.Close() }()
+	d := driver.NewFileWithOptions(driver.FileOptions{
+		Path:        filepath.Join(t.TempDir(), "sessions"),
+		IDGenerator: ids,
+	})
+	if err = manager.Extend("file", d); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+
+	s, err := manager.BuildSession(CookieName, "file")
+	if err != nil {
+		t.Fatalf("BuildSession failed: %v", err)
+	}
+	s.Start()
+	id := s.GetID()
+	if !strings.HasPrefix(id, "eu1-") || !ids.Valid(id) {
+		t.Fatalf("session ID %q was not created by the configured generator", id)
+	}
+	s.Put("k", "v")
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	manager.ReleaseSession(s)
+
+	s, _ = manager.BuildSession(CookieName, "file")
+	defer manager.ReleaseSession(s)
+	s.SetID(strings.Repeat("a", 32)) // valid for the default generator only
+	if s.GetID() == strings.Repeat("a", 32) {
+		t.Fatal("an ID the configured generator rejects was adopted")
+	}
+	s.SetID(id)
+	s.Start()
+	if s.GetID() != id || s.Get("k") != "v" {
+		t.Fatalf("stored session %q was not loaded back", id)
+	}
+}
+
 func TestSessionStartRegeneratesUnknownClientID(t *testing.T) {
 	driver := newMemoryDriver()
 	manager := testManagerWithDriver(t, driver)
@@ -311,6 +720,54 @@ func TestManagerSessionLocksAreCleanedUp(t *testing.T) {
 	}
 }
 
+func TestManagerSessionSemaphore(t *testing.T) {
+	manager := testManagerWithDriver(t, newMemoryDriver())
+	const id = "12345678901234567890123456789012"
+
+	for range 2 {
+		if err := manager.AcquireSessionSemaphore(context.Background(), id, 2); err != nil {
+			t.Fatalf("AcquireSessionSemaphore within the limit = %v", err)
+		}
+	}
+
+	// A done context turns the acquire into a try
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := manager.AcquireSessionSemaphore(done, id, 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AcquireSessionSemaphore over the limit = %v, want context.Canceled", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- manager.AcquireSessionSemaphore(context.Background(), id, 2)
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("AcquireSessionSemaphore returned %v while all slots were held", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	manager.ReleaseSessionSemaphore(id)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("queued AcquireSessionSemaphore = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued AcquireSessionSemaphore did not get the released slot")
+	}
+
+	for range 2 {
+		manager.ReleaseSessionSemaphore(id)
+	}
+	manager.ReleaseSessionSemaphore(id) // not held: no-op
+	manager.sessionLocksMu.Lock()
+	count := len(manager.sessionSems)
+	manager.sessionLocksMu.Unlock()
+	if count != 0 {
+		t.Fatalf("session semaphore map not cleaned up, count=%d", count)
+	}
+}
+
 func TestSessionFlashLifecycle(t *testing.T) {
 	d := newMemoryDriver()
 	manager := testManagerWithDriver(t, d)
@@ -474,6 +931,36 @@ func TestNewManagerAppliesDefaults(t *testing.T) {
 	}
 }
 
+// tickerCountingClock counts the tickers, and with them the garbage
+// collection goroutines, a manager starts.
+type tickerCountingClock struct {
+	clock.Clock
+	tickers atomic.Int32
+}
+
+func (c *tickerCountingClock) NewTicker(d time.Duration) clock.Ticker {
+	c.tickers.Add(1)
+	return c.Clock.NewTicker(d)
+}
+
+func TestNewManagerStartsNoGcTimerOnError(t *testing.T) {
+	clk := &tickerCountingClock{Clock: clock.System}
+	_, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		Clock:                clk,
+		MaxSessionsPerUser:   2,
+		SessionIndex:         newMemoryDriver(),
+		Remember:             &RememberOptions{},
+	})
+	if !errors.Is(err, ErrDriverNotSet) {
+		t.Fatalf("NewManager = %v, want ErrDriverNotSet", err)
+	}
+	if n := clk.tickers.Load(); n != 0 {
+		t.Fatalf("failed NewManager started %d garbage collection timers", n)
+	}
+}
+
 func TestManagerCloseClosesDriversAndIsIdempotent(t *testing.T) {
 	d := newMemoryDriver()
 	manager := testManagerWithDriver(t, d)
@@ -0,0 +1,153 @@
+// Package sessionid generates and validates session IDs.
+//
+// IDs end up in cookies, store keys and, with the file driver, file names,
+// so every Generator must only accept IDs made of ASCII letters, digits,
+// '-' and '_', and should produce IDs with at least 64 bits of entropy.
+package sessionid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaevor/go-nanoid"
+)
+
+// Generator creates session IDs and recognizes the IDs it creates.
+type Generator interface {
+	// New returns a new, unguessable session ID.
+	New() string
+	// Valid reports whether id has the shape of an ID returned by New. It
+	// is used to reject malformed cookie values before they reach a store.
+	Valid(id string) bool
+}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// DefaultLength is the length of IDs created by Default.
+const DefaultLength = 32
+
+// Default creates 32-character alphanumeric IDs (about 190 bits of entropy).
+var Default = Alphanumeric(DefaultLength)
+
+// Alphanumeric returns a Generator of random alphanumeric IDs of the given
+// length. It panics if length is below 16 (about 95 bits of entropy).
+func Alphanumeric(length int) Generator {
+	if length < 16 {
+		panic(fmt.Sprintf("sessionid: alphanumeric length %d is below 16", length))
+	}
+	return &alnum{
+		length: length,
+		// The nanoid generator is mutex-guarded internally, so creating it
+		// once avoids per-call initialization cost.
+		generate: nanoid.MustCustomASCII(alphanumeric, length),
+	}
+}
+
+type alnum struct {
+	length   int
+	generate func() string
+}
+
+func (g *alnum) New() string {
+	return g.generate()
+}
+
+func (g *alnum) Valid(id string) bool {
+	if len(id) != g.length {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !isAlnum(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Prefixed returns a Generator whose IDs are prefix followed by an ID of g,
+// e.g. a region or shard name load balancers can route on. The prefix may
+// only contain ASCII letters, digits, '-' and '_'; Prefixed panics
+// otherwise.
+func Prefixed(prefix string, g Generator) Generator {
+	for i := 0; i < len(prefix); i++ {
+		if c := prefix[i]; !isAlnum(c) && c != '-' && c != '_' {
+			panic(fmt.Sprintf("sessionid: invalid character %q in prefix %q", c, prefix))
+		}
+	}
+	return &prefixed{prefix: prefix, g: g}
+}
+
+type prefixed struct {
+	prefix string
+	g      Generator
+}
+
+func (p *prefixed) New() string {
+	return p.prefix + p.g.New()
+}
+
+func (p *prefixed) Valid(id string) bool {
+	rest, ok := strings.CutPrefix(id, p.prefix)
+	return ok && p.g.Valid(rest)
+}
+
+// UUIDv7 returns a Generator of RFC 9562 version 7 UUIDs in their canonical
+// lowercase form. They start with a millisecond timestamp, so IDs created
+// close together sort together, which keeps B-tree indexes of SQL stores
+// compact. They carry only 74 random bits, fewer than Default, and reveal
+// when the session was created.
+func UUIDv7() Generator {
+	return uuidV7{}
+}
+
+type uuidV7 struct{}
+
+func (uuidV7) New() string {
+	var u [16]byte
+	_, _ = rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+func (uuidV7) Valid(id string) bool {
+	if len(id) != 36 || id[14] != '7' || !strings.ContainsRune("89ab", rune(id[19])) {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,84 @@
+package sessionid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlphanumeric(t *testing.T) {
+	g := Alphanumeric(40)
+	id := g.New()
+	if len(id) != 40 || !g.Valid(id) {
+		t.Fatalf("New() = %q, want a valid 40-character ID", id)
+	}
+	if id == g.New() {
+		t.Fatal("New returned the same ID twice")
+	}
+	for _, bad := range []string{"", id[:39], id + "a", "../" + id[3:], strings.Repeat("-", 40)} {
+		if g.Valid(bad) {
+			t.Fatalf("Valid(%q) = true", bad)
+		}
+	}
+	if !Default.Valid(Default.New()) || len(Default.New()) != DefaultLength {
+		t.Fatal("Default does not accept its own IDs")
+	}
+}
+
+func TestAlphanumericRejectsShortLength(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Alphanumeric(8) did not panic")
+		}
+	}()
+	Alphanumeric(8)
+}
+
+func TestPrefixed(t *testing.T) {
+	g := Prefixed("eu-1_", Default)
+	id := g.New()
+	if !strings.HasPrefix(id, "eu-1_") || !g.Valid(id) {
+		t.Fatalf("New() = %q, want a valid prefixed ID", id)
+	}
+	if g.Valid(Default.New()) {
+		t.Fatal("an ID without the prefix is valid")
+	}
+	if g.Valid("us-1_" + Default.New()) {
+		t.Fatal("an ID with another prefix is valid")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Prefixed with a path separator did not panic")
+		}
+	}()
+	Prefixed("eu/", Default)
+}
+
+func TestUUIDv7(t *testing.T) {
+	g := UUIDv7()
+	first := g.New()
+	if len(first) != 36 || !g.Valid(first) {
+		t.Fatalf("New() = %q, want a valid UUIDv7", first)
+	}
+	if first[14] != '7' || !strings.ContainsRune("89ab", rune(first[19])) {
+		t.Fatalf("New() = %q has the wrong version or variant", first)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if second := g.New(); second <= first {
+		t.Fatalf("UUIDv7 %q does not sort after the earlier %q", second, first)
+	}
+
+	for _, bad := range []string{
+		"",
+		strings.ToUpper(first),
+		first[:14] + "4" + first[15:],
+		first[:8] + "_" + first[9:],
+		Default.New(),
+	} {
+		if g.Valid(bad) {
+			t.Fatalf("Valid(%q) = true", bad)
+		}
+	}
+}
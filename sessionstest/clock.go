@@ -0,0 +1,15 @@
+package sessionstest
+
+import (
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+)
+
+// Clock is the manually advanced clock of a Harness, a clock.Fake.
+type Clock = clock.Fake
+
+// NewClock returns a clock stopped at now.
+func NewClock(now time.Time) *Clock {
+	return clock.NewFake(now)
+}
@@ -0,0 +1,136 @@
+package sessionstest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libtnb/sessions/clock"
+)
+
+type entry struct {
+	data string
+	at   time.Time
+}
+
+// Driver is an in-memory session driver whose expiry follows a clock. It
+// implements driver.Driver, driver.Lister and driver.ActivityReporter.
+type Driver struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	minutes int
+	data    map[string]entry
+	writes  int
+}
+
+// NewDriver creates an in-memory driver treating sessions idle for longer
+// than minutes, as told by clk, as expired.
+func NewDriver(clk clock.Clock, minutes int) *Driver {
+	return &Driver{
+		clock:   clk,
+		minutes: minutes,
+		data:    make(map[string]entry),
+	}
+}
+
+func (d *Driver) Close() error {
+	return nil
+}
+
+func (d *Driver) Destroy(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.data, id)
+	return nil
+}
+
+func (d *Driver) Gc(maxLifetime int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cutoff := d.clock.Now().Add(-time.Duration(maxLifetime) * time.Second)
+	for id, e := range d.data {
+		if e.at.Before(cutoff) {
+			delete(d.data, id)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) Read(id string) (string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.data[id]
+	if !ok || d.expired(e) {
+		return "", false, nil
+	}
+	return e.data, true, nil
+}
+
+func (d *Driver) Touch(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.data[id]
+	if !ok || d.expired(e) {
+		return false, nil
+	}
+	e.at = d.clock.Now()
+	d.data[id] = e
+	return true, nil
+}
+
+func (d *Driver) LastActivity(id string) (time.Time, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.data[id]
+	if !ok || d.expired(e) {
+		return time.Time{}, false, nil
+	}
+	return e.at, true, nil
+}
+
+func (d *Driver) Write(id string, data string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data[id] = entry{data: data, at: d.clock.Now()}
+	d.writes++
+	return nil
+}
+
+func (d *Driver) List(fn func(id string, data string) bool) error {
+	d.mu.Lock()
+	var live []string
+	var data []string
+	for id, e := range d.data {
+		if !d.expired(e) {
+			live = append(live, id)
+			data = append(data, e.data)
+		}
+	}
+	d.mu.Unlock()
+
+	for i, id := range live {
+		if !fn(id, data[i]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Len returns the number of stored sessions, expired ones included until
+// Gc removes them.
+func (d *Driver) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.data)
+}
+
+// Writes returns how many times Write was called, to assert that a request
+// did or did not persist its session.
+func (d *Driver) Writes() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writes
+}
+
+func (d *Driver) expired(e entry) bool {
+	return !e.at.After(d.clock.Now().Add(-time.Duration(d.minutes) * time.Minute))
+}
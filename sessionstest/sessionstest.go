@@ -0,0 +1,197 @@
+// Package sessionstest helps testing handlers that use sessions: it builds a
+// Manager backed by an in-memory driver and a fake clock, seeds sessions,
+// attaches them to requests and inspects what was saved.
+//
+//	h := sessionstest.New(t)
+//	id := h.Seed(func(s *sessions.Session) { s.Put("cart", 3) })
+//	w := httptest.NewRecorder()
+//	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/cart", id))
+//	h.AssertValue(id, "cart", 3)
+//	h.Clock.Advance(3 * time.Hour) // the session expires
+package sessionstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions"
+)
+
+// Key is the encryption key of managers built by New.
+const Key = "sessionstest-key-0123456789abcde"
+
+// Harness bundles a Manager with the in-memory driver and fake clock behind
+// it.
+type Harness struct {
+	Manager *sessions.Manager
+	Driver  *Driver
+	Clock   *Clock
+	// CookieName is the session cookie name Attach sends and SessionID
+	// reads. New sets sessions.CookieName; match the middleware
+	// configuration under test, e.g. middleware.HostPrefix +
+	// sessions.CookieName for a Config.CookiePrefix of HostPrefix.
+	CookieName string
+
+	t testing.TB
+}
+
+// Saved is a session as found in the store.
+type Saved struct {
+	Attributes map[string]any
+	Meta       sessions.Meta
+}
+
+// New builds a Manager whose default driver is an in-memory Driver and whose
+// clock is a Clock starting at the current time. configure may adjust the
+// options (Lifetime, TouchInterval, Remember, ...); the key, driver and
+// clock are set up by New. The manager is closed when the test ends.
+func New(t testing.TB, configure ...func(*sessions.ManagerOptions)) *Harness {
+	t.Helper()
+
+	clk := NewClock(time.Now())
+	options := &sessions.ManagerOptions{Key: Key}
+	for _, fn := range configure {
+		fn(options)
+	}
+	options.DisableDefaultDriver = true
+	options.Clock = clk
+
+	manager, err := sessions.NewManager(options)
+	if err != nil {
+		t.Fatalf("sessionstest: NewManager failed: %v", err)
+	}
+	t.Cleanup(func() { _ = manager.Close() })
+
+	d := NewDriver(clk, manager.Lifetime)
+	if err = manager.Extend("default", d); err != nil {
+		t.Fatalf("sessionstest: Extend failed: %v", err)
+	}
+	return &Harness{Manager: manager, Driver: d, Clock: clk, CookieName: sessions.CookieName, t: t}
+}
+
+// Seed stores a new session prepared by fn and returns its ID.
+func (h *Harness) Seed(fn func(s *sessions.Session)) string {
+	h.t.Helper()
+
+	s, err := h.Manager.BuildSession(sessions.CookieName)
+	if err != nil {
+		h.t.Fatalf("sessionstest: BuildSession failed: %v", err)
+	}
+	defer h.Manager.ReleaseSession(s)
+
+	s.Start()
+	if fn != nil {
+		fn(s)
+	}
+	if err = s.Save(); err != nil {
+		h.t.Fatalf("sessionstest: Save failed: %v", err)
+	}
+	return s.GetID()
+}
+
+// Attach adds the session cookie named CookieName carrying id, signed when
+// the manager signs IDs, to r and returns r.
+func (h *Harness) Attach(r *http.Request, id string) *http.Request {
+	r.AddCookie(&http.Cookie{Name: h.CookieName, Value: h.Manager.SignID(id)})
+	return r
+}
+
+// NewRequest is httptest.NewRequest with the session cookie for id
+// attached; an empty id sends no cookie.
+func (h *Harness) NewRequest(method, target, id string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if id == "" {
+		return r
+	}
+	return h.Attach(r, id)
+}
+
+// SessionID returns the session ID the response set in its cookie named
+// CookieName, or "" when it set none.
+func (h *Harness) SessionID(w *httptest.ResponseRecorder) string {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == h.CookieName {
+			id, _ := h.Manager.VerifyID(cookie.Value)
+			return id
+		}
+	}
+	return ""
+}
+
+// Load returns the stored, unexpired session with the given ID.
+func (h *Harness) Load(id string) (Saved, bool) {
+	h.t.Helper()
+
+	s, err := h.Manager.BuildSession(sessions.CookieName)
+	if err != nil {
+		h.t.Fatalf("sessionstest: BuildSession failed: %v", err)
+	}
+	defer h.Manager.ReleaseSession(s)
+
+	s.SetID(id)
+	s.Start()
+	if s.GetID() != id {
+		return Saved{}, false
+	}
+	return Saved{Attributes: s.All(), Meta: s.Meta()}, true
+}
+
+// Gc runs the driver's garbage collection with the manager's lifetime, as
+// the manager's timer would.
+func (h *Harness) Gc() {
+	h.t.Helper()
+	if err := h.Driver.Gc(h.Manager.Lifetime * 60); err != nil {
+		h.t.Fatalf("sessionstest: Gc failed: %v", err)
+	}
+}
+
+// AssertValue fails the test unless the stored session id holds want under
+// key.
+func (h *Harness) AssertValue(id, key string, want any) {
+	h.t.Helper()
+	saved, ok := h.Load(id)
+	if !ok {
+		h.t.Errorf("sessionstest: session %q is not stored", id)
+		return
+	}
+	got, ok := saved.Attributes[key]
+	if !ok {
+		h.t.Errorf("sessionstest: session %q has no %q, want %#v", id, key, want)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		h.t.Errorf("sessionstest: session %q has %q = %#v, want %#v", id, key, got, want)
+	}
+}
+
+// AssertNoValue fails the test if the stored session id holds key.
+func (h *Harness) AssertNoValue(id, key string) {
+	h.t.Helper()
+	saved, ok := h.Load(id)
+	if !ok {
+		h.t.Errorf("sessionstest: session %q is not stored", id)
+		return
+	}
+	if got, ok := saved.Attributes[key]; ok {
+		h.t.Errorf("sessionstest: session %q has %q = %#v, want none", id, key, got)
+	}
+}
+
+// AssertStored fails the test unless session id is stored and unexpired.
+func (h *Harness) AssertStored(id string) {
+	h.t.Helper()
+	if _, ok := h.Load(id); !ok {
+		h.t.Errorf("sessionstest: session %q is not stored", id)
+	}
+}
+
+// AssertNotStored fails the test if session id is stored and unexpired.
+func (h *Harness) AssertNotStored(id string) {
+	h.t.Helper()
+	if _, ok := h.Load(id); ok {
+		h.t.Errorf("sessionstest: session %q is stored, want none", id)
+	}
+}
@@ -0,0 +1,140 @@
+package sessionstest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libtnb/sessions"
+	"github.com/libtnb/sessions/clock"
+	"github.com/libtnb/sessions/driver"
+	"github.com/libtnb/sessions/driver/drivertest"
+	"github.com/libtnb/sessions/middleware"
+	"github.com/libtnb/sessions/sessionstest"
+)
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() driver.Driver {
+		return sessionstest.NewDriver(clock.System, 10)
+	})
+}
+
+func TestDriverExpiryConformance(t *testing.T) {
+	drivertest.RunExpiry(t, func(clk clock.Clock, minutes int) driver.Driver {
+		return sessionstest.NewDriver(clk, minutes)
+	})
+}
+
+func counter(manager *sessions.Manager) http.Handler {
+	return middleware.StartSession(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := manager.GetSession(r)
+		views, _ := s.Get("views", 0).(int)
+		s.Put("views", views+1)
+		if msg, ok := s.Get("notice").(string); ok {
+			_, _ = w.Write([]byte(msg))
+		}
+	}))
+}
+
+func TestHarnessSeedAndAssert(t *testing.T) {
+	h := sessionstest.New(t)
+	handler := counter(h.Manager)
+
+	id := h.Seed(func(s *sessions.Session) {
+		s.Put("views", 41)
+		s.Flash("notice", "saved")
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+	if got := h.SessionID(w); got != id {
+		t.Fatalf("response cookie carries %q, want the seeded session %q", got, id)
+	}
+	if w.Body.String() != "saved" {
+		t.Fatalf("body = %q, want the flashed notice", w.Body.String())
+	}
+	h.AssertValue(id, "views", 42)
+
+	// The flash message is gone on the next request
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+	if w.Body.String() != "" {
+		t.Fatalf("body = %q, want the flash message consumed", w.Body.String())
+	}
+	h.AssertNoValue(id, "notice")
+	h.AssertValue(id, "views", 43)
+}
+
+func TestHarnessClockDrivesExpiry(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) {
+		o.Lifetime = 60
+	})
+	handler := counter(h.Manager)
+
+	id := h.Seed(func(s *sessions.Session) { s.Put("views", 1) })
+	created, _ := h.Load(id)
+
+	h.Clock.Advance(59 * time.Minute)
+	handler.ServeHTTP(httptest.NewRecorder(), h.NewRequest(http.MethodGet, "/", id))
+	h.AssertValue(id, "views", 2)
+	saved, _ := h.Load(id)
+	if got := saved.Meta.LastActivity.Sub(created.Meta.LastActivity); got != 59*time.Minute {
+		t.Fatalf("LastActivity advanced by %v, want the fake clock's 59m", got)
+	}
+
+	// Idle for longer than the lifetime: the old session is gone and the
+	// request starts a fresh one
+	h.Clock.Advance(61 * time.Minute)
+	h.AssertNotStored(id)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+	fresh := h.SessionID(w)
+	if fresh == "" || fresh == id {
+		t.Fatalf("expired session was reused: cookie %q", fresh)
+	}
+	h.AssertValue(fresh, "views", 1)
+
+	h.Gc()
+	if n := h.Driver.Len(); n != 1 {
+		t.Fatalf("%d sessions stored after Gc, want only the fresh one", n)
+	}
+}
+
+func TestHarnessClockDrivesGcTimer(t *testing.T) {
+	h := sessionstest.New(t, func(o *sessions.ManagerOptions) {
+		o.Lifetime = 60
+		o.GcInterval = 30
+	})
+
+	h.Seed(nil)
+	h.Clock.Advance(90 * time.Minute)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Driver.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("garbage collection timer did not run on the fake clock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHarnessCookieNameFollowsPrefix(t *testing.T) {
+	h := sessionstest.New(t)
+	h.CookieName = middleware.HostPrefix + sessions.CookieName
+	handler := middleware.StartSessionWithConfig(h.Manager, middleware.Config{
+		CookiePrefix: middleware.HostPrefix,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, _ := h.Manager.GetSession(r)
+		views, _ := s.Get("views", 0).(int)
+		s.Put("views", views+1)
+	}))
+
+	id := h.Seed(func(s *sessions.Session) { s.Put("views", 1) })
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, h.NewRequest(http.MethodGet, "/", id))
+	if got := h.SessionID(w); got != id {
+		t.Fatalf("response cookie carries %q, want the seeded session %q", got, id)
+	}
+	h.AssertValue(id, "views", 2)
+}
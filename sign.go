@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// idSignatureSize is the length of the truncated HMAC-SHA256 appended to
+// signed IDs; 128 bits is plenty to make forging infeasible.
+const idSignatureSize = 16
+
+// idSigningContext separates the ID signing key derived from
+// ManagerOptions.Key from the key's other uses.
+const idSigningContext = "sessions/id-signature"
+
+// minIDSigningKeySize is the minimum length of an ID signing key, matching
+// the output of the HMAC-SHA256 it keys.
+const minIDSigningKeySize = 32
+
+// ErrWeakIDSigningKey is returned by NewManager for an IDSigningKeys entry
+// shorter than 32 bytes.
+var ErrWeakIDSigningKey = errors.New("id signing key must be at least 32 bytes")
+
+// newIDSigningKeys returns the ID signing keys: the given ones when set,
+// otherwise a single key derived from the manager key.
+func newIDSigningKeys(key string, keys []string) ([][]byte, error) {
+	if len(keys) > 0 {
+		signing := make([][]byte, 0, len(keys))
+		for _, k := range keys {
+			if len(k) < minIDSigningKeySize {
+				return nil, ErrWeakIDSigningKey
+			}
+			signing = append(signing, []byte(k))
+		}
+		return signing, nil
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(idSigningContext))
+	return [][]byte{mac.Sum(nil)}, nil
+}
+
+// SignID returns the cookie value for a session ID: the ID followed by a
+// signature when ManagerOptions.SignIDs is set, the bare ID otherwise.
+func (m *Manager) SignID(id string) string {
+	if len(m.idKeys) == 0 {
+		return id
+	}
+	return id + "." + base64.RawURLEncoding.EncodeToString(signID(m.idKeys[0], id))
+}
+
+// VerifyID returns the session ID carried by a cookie value, and false when
+// signing is enabled and the value is not signed by any of the ID signing
+// keys. A rejected value needs no store lookup: it cannot name a session
+// this manager created.
+func (m *Manager) VerifyID(value string) (string, bool) {
+	if len(m.idKeys) == 0 {
+		return value, true
+	}
+	id, encoded, ok := cutLast(value, ".")
+	if !ok {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(signature) != idSignatureSize {
+		return "", false
+	}
+	for _, key := range m.idKeys {
+		if hmac.Equal(signature, signID(key, id)) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func signID(key []byte, id string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)[:idSignatureSize]
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
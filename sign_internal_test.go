@@ -0,0 +1,103 @@
+package sessions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	oldIDKey = "old-id-signing-key-0123456789abcdef"
+	newIDKey = "new-id-signing-key-0123456789abcdef"
+)
+
+func mustIDSigningKeys(t *testing.T, key string, keys ...string) [][]byte {
+	t.Helper()
+	signing, err := newIDSigningKeys(key, keys)
+	if err != nil {
+		t.Fatalf("newIDSigningKeys failed: %v", err)
+	}
+	return signing
+}
+
+func TestSignIDRoundTripAndRotation(t *testing.T) {
+	manager := testManagerWithDriver(t, newMemoryDriver())
+	id := manager.ids.New()
+
+	if got := manager.SignID(id); got != id {
+		t.Fatalf("SignID without SignIDs = %q, want the bare ID", got)
+	}
+	if got, ok := manager.VerifyID(id); !ok || got != id {
+		t.Fatalf("VerifyID without SignIDs = %q, %v", got, ok)
+	}
+
+	manager.idKeys = mustIDSigningKeys(t, "", oldIDKey)
+	signedOld := manager.SignID(id)
+	if got, ok := manager.VerifyID(signedOld); !ok || got != id {
+		t.Fatalf("VerifyID(%q) = %q, %v", signedOld, got, ok)
+	}
+
+	// Rotation: the new key signs, the old one still verifies
+	manager.idKeys = mustIDSigningKeys(t, "", newIDKey, oldIDKey)
+	signedNew := manager.SignID(id)
+	if signedNew == signedOld {
+		t.Fatal("SignID still signs with the old key after rotation")
+	}
+	for _, value := range []string{signedNew, signedOld} {
+		if got, ok := manager.VerifyID(value); !ok || got != id {
+			t.Fatalf("VerifyID(%q) after rotation = %q, %v", value, got, ok)
+		}
+	}
+
+	// Once the old key is dropped, its signatures are rejected
+	manager.idKeys = mustIDSigningKeys(t, "", newIDKey)
+	if _, ok := manager.VerifyID(signedOld); ok {
+		t.Fatal("VerifyID accepted a signature of a dropped key")
+	}
+	for _, forged := range []string{id, id + ".", id + ".AAAAAAAAAAAAAAAAAAAAAA", "x" + signedNew[1:]} {
+		if _, ok := manager.VerifyID(forged); ok {
+			t.Fatalf("VerifyID(%q) = true", forged)
+		}
+	}
+}
+
+func TestSignIDDerivesKeyFromManagerKey(t *testing.T) {
+	a := mustIDSigningKeys(t, "12345678901234567890123456789012")
+	b := mustIDSigningKeys(t, "abcdefghijklmnopqrstuvwxyz012345")
+	if len(a) != 1 || string(a[0]) == "12345678901234567890123456789012" {
+		t.Fatal("the signing key must be derived, not the encryption key itself")
+	}
+	if string(a[0]) == string(b[0]) {
+		t.Fatal("different manager keys derived the same signing key")
+	}
+}
+
+func TestNewManagerRejectsWeakIDSigningKeys(t *testing.T) {
+	for name, keys := range map[string][]string{
+		"empty": {newIDKey, ""},
+		"short": {strings.Repeat("k", 31)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewManager(&ManagerOptions{
+				Key:                  "12345678901234567890123456789012",
+				DisableDefaultDriver: true,
+				SignIDs:              true,
+				IDSigningKeys:        keys,
+			})
+			if !errors.Is(err, ErrWeakIDSigningKey) {
+				t.Fatalf("NewManager = %v, want ErrWeakIDSigningKey", err)
+			}
+		})
+	}
+
+	manager, err := NewManager(&ManagerOptions{
+		Key:                  "12345678901234567890123456789012",
+		DisableDefaultDriver: true,
+		SignIDs:              true,
+		IDSigningKeys:        []string{strings.Repeat("k", 32)},
+	})
+	if err != nil {
+		t.Fatalf("NewManager with a 32-byte key failed: %v", err)
+	}
+	_ = manager.Close()
+}